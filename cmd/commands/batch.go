@@ -0,0 +1,226 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+// batchStatus is the outcome of requerying a single stored run in a batch
+type batchStatus string
+
+const (
+	batchStatusOK      batchStatus = "ok"
+	batchStatusFailed  batchStatus = "failed"
+	batchStatusSkipped batchStatus = "skipped"
+	batchStatusError   batchStatus = "error"
+)
+
+// batchResult records the outcome of requerying a single stored run in a batch
+type batchResult struct {
+	source string
+	dest   string
+	status batchStatus
+	total  int
+	failed int
+	err    error
+}
+
+var (
+	batchOpts         requeryOptions
+	batchList         string
+	batchParallelDirs int
+)
+
+// batchCmd represents the batch command
+var batchCmd = &cobra.Command{
+	Use:   "batch [PREFIX]",
+	Short: "Requery every stored run under a prefix",
+	Long: `Requery every stored run under PREFIX, or S3_BUCKET_DIR when omitted, or every directory listed in --list.
+Every directory containing stored results is a run. Runs of named windows are stored in sub-directories and are requeried separately.
+
+Runs are requeried --parallel-dirs at a time. QUERY_PARALLELISM and QUERY_RATE_LIMIT are the budget of the whole batch
+and are split between the runs in flight.
+Runs whose destination manifest already records every selected query as stored for the same query and range
+are skipped unless --force is set.
+A summary of every run is printed when the batch finishes.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config := loadConfig(cmd)
+		prefix := config.S3BucketDir
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+
+		var dirs []string
+		var err error
+		if batchList != "" {
+			prefix = ""
+			dirs, err = readBatchList(batchList)
+		} else {
+			config.S3BucketDir = prefix
+			dirs, err = usecases.NewStorageAdapter(config).ListRuns()
+		}
+		if err != nil {
+			slog.Error("Failed to list stored runs", "err", err)
+			os.Exit(1)
+		}
+		dirs = excludeRequeryDirs(dirs, batchOpts)
+		if len(dirs) == 0 {
+			slog.Error("No stored runs found", "prefix", prefix, "list", batchList)
+			os.Exit(1)
+		}
+		slog.Info("Requerying stored runs.", "runs", len(dirs), "parallelDirs", batchParallelDirs)
+
+		results := runBatch(config, prefix, dirs, batchParallelDirs, batchOpts, func(config *domain.Config, dir string, opts requeryOptions) batchResult {
+			return requeryBatchRun(cmd, config, dir, opts)
+		})
+
+		printBatchSummary(cmd.OutOrStdout(), results)
+		for _, result := range results {
+			if result.status == batchStatusFailed || result.status == batchStatusError {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+func init() {
+	addRequeryFlags(batchCmd, &batchOpts)
+	batchCmd.Flags().StringVar(&batchOpts.dest, "dest", "", "Prefix to write results to. Runs keep their path relative to PREFIX. Defaults to the source directory with -requery appended to its first path segment")
+	batchCmd.Flags().StringVar(&batchList, "list", "", "File listing the directories of the runs to requery, one per line. Blank lines and lines starting with # are ignored")
+	batchCmd.Flags().IntVar(&batchParallelDirs, "parallel-dirs", 4, "Number of runs to requery at the same time")
+	batchCmd.MarkFlagsMutuallyExclusive("dest", "merge")
+	rootCmd.AddCommand(batchCmd)
+}
+
+// batchRunFunc requeries a single run of a batch with the share of the budget in config
+type batchRunFunc func(config *domain.Config, dir string, opts requeryOptions) batchResult
+
+// runBatch requeries every run in dirs with run, at most parallelDirs at a time.
+// The query parallelism and rate limit of config are split between the runs in flight.
+// A bounded query parallelism also bounds the runs in flight, so that every run has a worker without exceeding the budget.
+func runBatch(config *domain.Config, prefix string, dirs []string, parallelDirs int, opts requeryOptions, run batchRunFunc) []batchResult {
+	parallelDirs = max(1, min(parallelDirs, len(dirs)))
+	budget := *config
+	if config.QueryParallelism > 0 {
+		parallelDirs = min(parallelDirs, config.QueryParallelism)
+		budget.QueryParallelism = config.QueryParallelism / parallelDirs
+	}
+	budget.QueryRateLimit = config.QueryRateLimit / float64(parallelDirs)
+
+	results := make([]batchResult, len(dirs))
+	semaphore := make(chan struct{}, parallelDirs)
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			runConfig := budget
+			runOpts := opts
+			if opts.dest != "" {
+				runOpts.dest = path.Join(opts.dest, strings.TrimPrefix(strings.TrimPrefix(dir, prefix), "/"))
+			}
+			results[i] = run(&runConfig, dir, runOpts)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// requeryBatchRun requeries a single run of a batch and records its outcome
+func requeryBatchRun(cmd *cobra.Command, config *domain.Config, dir string, opts requeryOptions) batchResult {
+	result := batchResult{source: dir}
+	report, skipped, err := requeryRun(cmd, config, dir, opts)
+	result.dest = config.S3BucketDir
+	switch {
+	case err != nil:
+		slog.Error("Failed to requery stored run", "source", dir, "err", err)
+		result.status = batchStatusError
+		result.err = err
+	case skipped:
+		result.status = batchStatusSkipped
+	default:
+		result.total = report.Total()
+		result.failed = report.Failed()
+		result.status = batchStatusOK
+		if logReport(report, config.FailurePolicy) {
+			result.status = batchStatusFailed
+		}
+	}
+	return result
+}
+
+// readBatchList reads the directories listed in the file at name.
+// Blank lines and lines starting with # are ignored.
+func readBatchList(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open list file %q, %w", name, err)
+	}
+	defer file.Close()
+
+	var dirs []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir := strings.Trim(path.Clean(line), "/")
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Unable to read list file %q, %w", name, err)
+	}
+	return dirs, nil
+}
+
+// excludeRequeryDirs removes the directories that are the default requery destination of another listed directory,
+// so that the results of a previous batch are not requeried again
+func excludeRequeryDirs(dirs []string, opts requeryOptions) []string {
+	if opts.merge || opts.dest != "" {
+		return dirs
+	}
+	destinations := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		destinations[requeryDir(dir)] = true
+	}
+	return slices.DeleteFunc(slices.Clone(dirs), func(dir string) bool {
+		return destinations[dir]
+	})
+}
+
+// printBatchSummary writes a table of the outcome of every run of a batch to w
+func printBatchSummary(w io.Writer, results []batchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tDEST\tSTATUS\tQUERIES\tFAILED\tERROR")
+	counts := map[batchStatus]int{}
+	for _, result := range results {
+		counts[result.status]++
+		errText := ""
+		if result.err != nil {
+			errText = result.err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", result.source, result.dest, result.status, result.total, result.failed, errText)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d runs: %d ok, %d failed, %d skipped, %d error\n",
+		len(results), counts[batchStatusOK], counts[batchStatusFailed], counts[batchStatusSkipped], counts[batchStatusError])
+}
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus/prometheustest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunBatch(t *testing.T) {
+	dirs := []string{"exp/run-1", "exp/run-2", "exp/run-3", "exp/run-4", "exp/run-5", "exp/run-6"}
+	tests := []struct {
+		name            string
+		parallelism     int
+		rateLimit       float64
+		parallelDirs    int
+		wantParallelism int
+		wantRateLimit   float64
+		wantMaxRuns     int
+		wantMaxQueries  int
+	}{
+		{name: "unbounded parallelism stays unbounded", parallelism: 0, parallelDirs: 4, wantParallelism: 0, wantMaxRuns: 4},
+		{name: "parallelism is split between runs", parallelism: 8, rateLimit: 4, parallelDirs: 4, wantParallelism: 2, wantRateLimit: 1, wantMaxRuns: 4, wantMaxQueries: 8},
+		{name: "parallelism below parallel dirs caps the runs", parallelism: 2, rateLimit: 4, parallelDirs: 4, wantParallelism: 1, wantRateLimit: 2, wantMaxRuns: 2, wantMaxQueries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &domain.Config{QueryParallelism: tt.parallelism, QueryRateLimit: tt.rateLimit}
+			var mu sync.Mutex
+			inFlight, maxRuns := 0, 0
+			run := func(config *domain.Config, dir string, opts requeryOptions) batchResult {
+				mu.Lock()
+				inFlight++
+				maxRuns = max(maxRuns, inFlight)
+				mu.Unlock()
+				assert.Equal(t, tt.wantParallelism, config.QueryParallelism)
+				assert.Equal(t, tt.wantRateLimit, config.QueryRateLimit)
+				time.Sleep(20 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return batchResult{source: dir, dest: opts.dest, status: batchStatusOK}
+			}
+
+			results := runBatch(config, "exp", dirs, tt.parallelDirs, requeryOptions{dest: "fixed"}, run)
+
+			assert.Len(t, results, len(dirs))
+			for i, result := range results {
+				assert.Equal(t, dirs[i], result.source)
+				assert.Equal(t, "fixed/"+strings.TrimPrefix(dirs[i], "exp/"), result.dest)
+			}
+			assert.LessOrEqual(t, maxRuns, tt.wantMaxRuns)
+			if tt.wantMaxQueries > 0 {
+				assert.LessOrEqual(t, maxRuns*tt.wantParallelism, tt.wantMaxQueries)
+			}
+			// the config of the batch is left untouched
+			assert.Equal(t, tt.parallelism, config.QueryParallelism)
+		})
+	}
+}
+
+func TestRequeryBatchRunErrors(t *testing.T) {
+	server := httptest.NewServer(prometheustest.NewAPI())
+	t.Cleanup(server.Close)
+
+	catalogs := t.TempDir()
+	writeCatalog := func(name, content string) string {
+		catalogPath := filepath.Join(catalogs, name)
+		if err := os.WriteFile(catalogPath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return catalogPath
+	}
+	querySets := map[string]string{
+		"exp/run-1": writeCatalog("up.yaml", "groups:\n  - queries:\n      - {name: up, expr: [{metric: up}]}\n"),
+		"exp/run-2": filepath.Join(catalogs, "missing.yaml"),
+		"exp/run-3": writeCatalog("short.yaml", "groups:\n  - queries:\n      - {name: up_rate, expr: [{metric: up}, {rate: {duration: 10s}}]}\n"),
+	}
+
+	config := &domain.Config{
+		MetricsQueryEndpoint: server.URL,
+		StorageBackend:       domain.StorageBackendFilesystem,
+		StorageRoot:          t.TempDir(),
+		ScrapeInterval:       15 * time.Second,
+	}
+	end := time.Date(2021, 1, 1, 1, 0, 0, 0, time.UTC)
+	dirs := []string{"exp/run-1", "exp/run-2", "exp/run-3"}
+	for _, dir := range dirs {
+		runConfig := *config
+		runConfig.S3BucketDir = dir
+		manifest := &domain.Manifest{
+			QuerySet: querySets[dir],
+			Start:    end.Add(-time.Hour),
+			End:      end,
+			Config:   domain.ManifestConfig{Step: "15s", Namespace: "ns"},
+		}
+		assert.NoError(t, usecases.NewStorageAdapter(&runConfig).WriteManifest(manifest))
+	}
+
+	results := runBatch(config, "exp", dirs, 2, requeryOptions{}, func(config *domain.Config, dir string, opts requeryOptions) batchResult {
+		return requeryBatchRun(requeryCmd, config, dir, opts)
+	})
+
+	assert.Equal(t, batchStatusOK, results[0].status)
+	assert.Equal(t, 1, results[0].total)
+	assert.Equal(t, batchStatusError, results[1].status)
+	assert.ErrorContains(t, results[1].err, "Failed to open catalog")
+	assert.Equal(t, batchStatusError, results[2].status)
+	assert.ErrorContains(t, results[2].err, "Rate windows too short")
+}
@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hanapedia/metrics-processor/internal/application/core"
+	"github.com/hanapedia/metrics-processor/internal/application/usecases"
+	"github.com/hanapedia/metrics-processor/internal/application/usecases/catalog"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+var catalogArgsHelp = fmt.Sprintf("CATALOG is either a built-in catalog (%s) or a path to a YAML/JSON catalog file.", strings.Join(catalog.BuiltinNames(), ", "))
+
+// catalogCmd represents the catalog command
+var catalogCmd = &cobra.Command{
+	Use:   "catalog CATALOG",
+	Short: "Query metrics described by a query catalog",
+	Long:  catalogArgsHelp,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		queryCatalog := usecases.OpenCatalog(args[0])
+		runWindows(loadConfig(cmd), func(config *domain.Config) *domain.RunReport {
+			prometheusAdapter := usecases.CatalogPrometheusQueryAdapter(config, queryCatalog)
+			exitOnInvalidQueries(prometheusAdapter)
+			storageAdapter := usecases.NewStorageAdapter(config)
+
+			processor := core.NewMetricsProcessor(prometheusAdapter, storageAdapter, runInfo(cmd, args[0], config))
+			return processor.Process()
+		})
+	},
+}
+
+// catalogDryCmd represents the catalog dry command
+var catalogDryCmd = &cobra.Command{
+	Use:   "catalog-dry CATALOG",
+	Short: "View Queries described by a query catalog",
+	Long:  catalogArgsHelp,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+
+		config := loadConfig(cmd)
+		prometheusAdapter := usecases.CatalogPrometheusQueryAdapter(config, usecases.OpenCatalog(args[0]))
+		prometheusAdapter.PrintQuery()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(catalogCmd)
+	rootCmd.AddCommand(catalogDryCmd)
+}
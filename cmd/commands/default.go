@@ -3,7 +3,7 @@ package commands
 import (
 	"github.com/hanapedia/metrics-processor/internal/application/core"
 	"github.com/hanapedia/metrics-processor/internal/application/usecases"
-	"github.com/hanapedia/metrics-processor/internal/infrastructure/config"
+	"github.com/hanapedia/metrics-processor/internal/domain"
 	"github.com/spf13/cobra"
 )
 
@@ -12,12 +12,14 @@ var defaultCmd = &cobra.Command{
 	Use:   "default",
 	Short: "Query default metrics",
 	Run: func(cmd *cobra.Command, args []string) {
-		config := config.NewConfigFromEnv()
-		prometheusAdapter := usecases.PrometheusQueryAdapter(config)
-		s3Adapter := usecases.NewS3Adapter(config)
+		runWindows(loadConfig(cmd), func(config *domain.Config) *domain.RunReport {
+			prometheusAdapter := usecases.PrometheusQueryAdapter(config)
+			exitOnInvalidQueries(prometheusAdapter)
+			storageAdapter := usecases.NewStorageAdapter(config)
 
-		processor := core.NewMetricsProcessor(prometheusAdapter, s3Adapter)
-		processor.Process()
+			processor := core.NewMetricsProcessor(prometheusAdapter, storageAdapter, runInfo(cmd, "default", config))
+			return processor.Process()
+		})
 	},
 }
 
@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus/prometheustest"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fakePrometheusListen   string
+	fakePrometheusFixtures string
+	fakePrometheusSeries   int
+)
+
+// fakePrometheusCmd represents the fake-prometheus command
+var fakePrometheusCmd = &cobra.Command{
+	Use:   "fake-prometheus",
+	Short: "Serve a fake Prometheus HTTP API for demos",
+	Long: `Serve a fake Prometheus HTTP API with the query_range, query, series, labels and buildinfo endpoints.
+Queries listed in --fixtures return the samples of their fixture. Any other valid query returns deterministic synthetic series
+labelled by the grouping labels of its outermost aggregation.
+
+Point METRICS_QUERY_ENDPOINT at it to try the query sets without a cluster.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		api := prometheustest.NewAPI().SetGenerator(prometheustest.Synthetic(fakePrometheusSeries))
+		if fakePrometheusFixtures != "" {
+			if err := api.LoadFixtures(fakePrometheusFixtures); err != nil {
+				slog.Error("Failed to load fixtures", "err", err)
+				os.Exit(1)
+			}
+		}
+		slog.Info("Serving fake Prometheus API.", "listen", fakePrometheusListen, "fixtures", fakePrometheusFixtures)
+		if err := http.ListenAndServe(fakePrometheusListen, api); err != nil {
+			slog.Error("Failed to serve fake Prometheus API", "err", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	fakePrometheusCmd.Flags().StringVar(&fakePrometheusListen, "listen", "127.0.0.1:9090", "Address to serve the API on")
+	fakePrometheusCmd.Flags().StringVar(&fakePrometheusFixtures, "fixtures", "", "Json file of query results to serve, a list of objects with the query and its result")
+	fakePrometheusCmd.Flags().IntVar(&fakePrometheusSeries, "series", 3, "Number of synthetic series returned by queries without a fixture")
+	rootCmd.AddCommand(fakePrometheusCmd)
+}
@@ -9,26 +9,27 @@ import (
 	"time"
 
 	"github.com/hanapedia/metrics-processor/internal/application/core"
+	"github.com/hanapedia/metrics-processor/internal/application/port"
 	"github.com/hanapedia/metrics-processor/internal/application/usecases"
-	"github.com/hanapedia/metrics-processor/internal/infrastructure/config"
 	"github.com/spf13/cobra"
 )
 
 // hexagonRequeryCmd represents the hexagon requery command
 var hexagonRequeryCmd = &cobra.Command{
-	Use:   "hexagon-requery",
-	Short: "Requery Hexagon metrics",
+	Use:        "hexagon-requery",
+	Short:      "Requery Hexagon metrics",
+	Deprecated: "use requery instead",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		config := config.NewConfigFromEnv()
-		readS3Adapter := usecases.NewS3Adapter(config)
-		// extract endtime for this query using s3 bucket_dir provided
-		end, err := readS3Adapter.ParseEndTime()
+		config := loadConfig(cmd)
+		readStorageAdapter := usecases.NewStorageAdapter(config)
+		// extract endtime for this query using bucket_dir provided
+		end, err := readEndTime(readStorageAdapter)
 		if err != nil {
 			slog.Error("Error parsing end time.", "error", err)
 			os.Exit(1)
 		}
-		config.EndTime = parseTimestampWithPastCheck(end)
+		config.EndTime = end
 
 		// replace target dir
 		parts := strings.Split(config.S3BucketDir, string(filepath.Separator))
@@ -40,14 +41,15 @@ var hexagonRequeryCmd = &cobra.Command{
 
 		config.S3BucketDir = filepath.Join(parts...)
 
-		// recreate s3 adapter with updated config
-		writeS3Adapter := usecases.NewS3Adapter(config)
+		// recreate storage adapter with updated config
+		writeStorageAdapter := usecases.NewStorageAdapter(config)
 
 		// create subset query
 		prometheusAdapter := usecases.SubsetPrometheusQueryAdapter(config)
+		exitOnInvalidQueries(prometheusAdapter)
 
-		processor := core.NewMetricsProcessor(prometheusAdapter, writeS3Adapter)
-		processor.Process()
+		processor := core.NewMetricsProcessor(prometheusAdapter, writeStorageAdapter, runInfo(cmd, "subset", config))
+		exitOnFailure(processor.Process(), config.FailurePolicy)
 	},
 }
 
@@ -57,7 +59,7 @@ var hexagonRequeryDryCmd = &cobra.Command{
 	Short: "View Queries for requery of Hexagon metrics",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		config := config.NewConfigFromEnv()
+		config := loadConfig(cmd)
 		prometheusAdapter := usecases.SubsetPrometheusQueryAdapter(config)
 		prometheusAdapter.PrintQuery()
 	},
@@ -68,23 +70,16 @@ func init() {
 	rootCmd.AddCommand(hexagonRequeryDryCmd)
 }
 
-// Detects and parses the old timestamp used prior to v2.0.4
-// the bug divided the end timestamp with 10e3 instead of 10e2
-func parseTimestampWithPastCheck(end float64) time.Time {
-    // Parse the timestamp into seconds and nanoseconds
-    seconds := int64(end)
-    nanoSeconds := int64((end - float64(seconds)) * 1e9)
-    parsedTime := time.Unix(seconds, nanoSeconds)
-
-    // Check if the parsed time is way in the past (5 years ago or earlier)
-    fiftyYearsAgo := time.Now().AddDate(-5, 0, 0)
-    if parsedTime.Before(fiftyYearsAgo) {
-        // If it's way in the past, the timestamp might be off by a factor of 10, so correct it
-        end *= 10
-        seconds = int64(end)
-        nanoSeconds = int64((end - float64(seconds)) * 1e9)
-        parsedTime = time.Unix(seconds, nanoSeconds)
-    }
-
-    return parsedTime
+// readEndTime reads the end time of a stored run from its manifest.
+// Runs stored before manifests were introduced fall back to the end time of the first stored result.
+func readEndTime(source port.MetricsSourcePort) (time.Time, error) {
+	manifest, err := source.ReadManifest()
+	if err == nil {
+		slog.Info("Read end time from manifest.", "end", manifest.End)
+		return manifest.End, nil
+	}
+	slog.Warn("Unable to read manifest. Falling back to the first stored result.", "error", err)
+
+	// Results stored before v2.0.4 scaled the end time incorrectly, which is corrected when they are decoded
+	return source.ParseEndTime()
 }
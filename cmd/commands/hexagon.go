@@ -3,7 +3,7 @@ package commands
 import (
 	"github.com/hanapedia/metrics-processor/internal/application/core"
 	"github.com/hanapedia/metrics-processor/internal/application/usecases"
-	"github.com/hanapedia/metrics-processor/internal/infrastructure/config"
+	"github.com/hanapedia/metrics-processor/internal/domain"
 	"github.com/spf13/cobra"
 )
 
@@ -13,12 +13,14 @@ var hexagonCmd = &cobra.Command{
 	Short: "Query Hexagon metrics",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		config := config.NewConfigFromEnv()
-		prometheusAdapter := usecases.HexagonPrometheusQueryAdapter(config)
-		s3Adapter := usecases.NewS3Adapter(config)
+		runWindows(loadConfig(cmd), func(config *domain.Config) *domain.RunReport {
+			prometheusAdapter := usecases.HexagonPrometheusQueryAdapter(config)
+			exitOnInvalidQueries(prometheusAdapter)
+			storageAdapter := usecases.NewStorageAdapter(config)
 
-		processor := core.NewMetricsProcessor(prometheusAdapter, s3Adapter)
-		processor.Process()
+			processor := core.NewMetricsProcessor(prometheusAdapter, storageAdapter, runInfo(cmd, "hexagon", config))
+			return processor.Process()
+		})
 	},
 }
 
@@ -28,7 +30,7 @@ var hexagonDryCmd = &cobra.Command{
 	Short: "View Queries for Hexagon metrics",
 	Run: func(cmd *cobra.Command, args []string) {
 
-		config := config.NewConfigFromEnv()
+		config := loadConfig(cmd)
 		prometheusAdapter := usecases.HexagonPrometheusQueryAdapter(config)
 		prometheusAdapter.PrintQuery()
 	},
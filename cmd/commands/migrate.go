@@ -0,0 +1,116 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/format"
+	"github.com/spf13/cobra"
+)
+
+var migrateDryRun bool
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [PREFIX]",
+	Short: "Rewrite stored runs in the current result schema",
+	Long: `Rewrite the json results of every stored run under PREFIX, or S3_BUCKET_DIR when omitted, in the current schema.
+Results are rewritten in place and keep their compression. Results already in the current schema and Parquet results are left as is.
+
+End times of results stored before v2.0.4, which were scaled down by a factor of ten, are detected and corrected.
+The query text, start and step of each result are read from the manifest of its run when there is one,
+and the checksums and sizes recorded in the manifest are updated.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config := loadConfig(cmd)
+		prefix := config.S3BucketDir
+		if len(args) == 1 {
+			prefix = args[0]
+		}
+
+		config.S3BucketDir = prefix
+		dirs, err := usecases.NewStorageAdapter(config).ListRuns()
+		if err != nil {
+			slog.Error("Failed to list stored runs", "err", err)
+			os.Exit(1)
+		}
+		if len(dirs) == 0 {
+			slog.Error("No stored runs found", "prefix", prefix)
+			os.Exit(1)
+		}
+
+		var migrated, failed int
+		for _, dir := range dirs {
+			runConfig := *config
+			runConfig.S3BucketDir = dir
+			count, err := migrateRun(usecases.NewStorageAdapter(&runConfig), dir, migrateDryRun)
+			migrated += count
+			if err != nil {
+				slog.Error("Failed to migrate stored run", "dir", dir, "err", err)
+				failed++
+			}
+		}
+		slog.Info("Migration finished.", "runs", len(dirs), "failedRuns", failed, "migrated", migrated, "dryRun", migrateDryRun)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateDryRun, "dry-run", false, "Report the results that would be migrated without rewriting them")
+	rootCmd.AddCommand(migrateCmd)
+}
+
+// migrateRun rewrites the legacy results of the run stored in dir and returns the number of results migrated.
+// The manifest of the run is updated with the checksums of the rewritten results.
+func migrateRun(storage usecases.StorageAdapter, dir string, dryRun bool) (int, error) {
+	manifest, err := storage.ReadManifest()
+	if err != nil {
+		slog.Warn("Unable to read manifest. Migrating without query text, start and step.", "dir", dir, "error", err)
+		manifest = nil
+	}
+
+	keys, err := storage.ListResults()
+	if err != nil {
+		return 0, err
+	}
+	migrated := 0
+	for _, key := range keys {
+		data, err := storage.ReadResult(key)
+		if err != nil {
+			return migrated, err
+		}
+		rewritten, rescaled, err := format.Migrate(key, data, manifest)
+		if err != nil {
+			return migrated, fmt.Errorf("Unable to migrate %q, %w", key, err)
+		}
+		if rewritten == nil {
+			continue
+		}
+		if rescaled {
+			slog.Info("Detected legacy end time scaling.", "key", key)
+		}
+		migrated++
+		if dryRun {
+			slog.Info("Would migrate result.", "key", key)
+			continue
+		}
+		if err := storage.WriteResult(key, rewritten); err != nil {
+			return migrated, err
+		}
+		if manifest != nil {
+			manifest.Rewritten(key, rewritten)
+		}
+		slog.Info("Migrated result.", "key", key)
+	}
+
+	if manifest != nil && migrated > 0 && !dryRun {
+		if err := storage.WriteManifest(manifest); err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+)
+
+// exitOnFailure logs the run report and exits with non-zero status when the run failed according to policy
+func exitOnFailure(report *domain.RunReport, policy domain.FailurePolicy) {
+	if logReport(report, policy) {
+		os.Exit(1)
+	}
+}
+
+// runWindows runs process with the config of every window, or once when no window is set.
+// It exits with non-zero status when any of the runs failed according to the failure policy.
+func runWindows(config *domain.Config, process func(config *domain.Config) *domain.RunReport) {
+	failed := false
+	for _, runConfig := range config.RunConfigs() {
+		if runConfig.Window != "" {
+			slog.Info("Running window.", "window", runConfig.Window, "start", runConfig.StartTime(), "end", runConfig.EndTime, "dir", runConfig.S3BucketDir)
+		}
+		if logReport(process(runConfig), config.FailurePolicy) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// logReport logs the run report and reports whether the run failed according to policy
+func logReport(report *domain.RunReport, policy domain.FailurePolicy) bool {
+	for _, result := range report.Results() {
+		if result.Status != domain.QueryStatusOK {
+			slog.Error("Query did not complete.", "name", result.Name, "status", result.Status, "error", result.Error)
+		}
+	}
+	if err := report.ManifestError(); err != nil {
+		slog.Error("Manifest was not written.", "error", err)
+	}
+	slog.Info("Run finished.", "total", report.Total(), "skipped", report.Skipped(), "failed", report.Failed(), "policy", policy.Mode)
+
+	if report.ShouldFail(policy) {
+		slog.Error("Run failed according to failure policy.", "policy", policy.Mode, "threshold", policy.ThresholdPercent)
+		return true
+	}
+	return false
+}
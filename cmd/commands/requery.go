@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hanapedia/metrics-processor/internal/application/core"
+	"github.com/hanapedia/metrics-processor/internal/application/port"
+	"github.com/hanapedia/metrics-processor/internal/application/usecases"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+// requeryOptions selects the queries to requery and where their results are written
+type requeryOptions struct {
+	querySet string
+	queries  []string
+	dest     string
+	merge    bool
+}
+
+var requeryOpts requeryOptions
+
+// requeryCmd represents the requery command
+var requeryCmd = &cobra.Command{
+	Use:   "requery [SOURCE_DIR]",
+	Short: "Requery the window of a stored run",
+	Long: `Requery the window of the run stored in SOURCE_DIR, or S3_BUCKET_DIR when omitted.
+The query range, step and workload settings are read from the manifest of the run.
+Runs stored without a manifest fall back to the end time of the first stored result and the configured DURATION and STEP.
+
+Results are written to --dest, by default SOURCE_DIR with -requery appended to its first path segment,
+or merged into SOURCE_DIR with --merge.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		config := loadConfig(cmd)
+		source := config.S3BucketDir
+		if len(args) == 1 {
+			source = args[0]
+		}
+
+		os.Exit(requery(cmd, config, source, requeryOpts))
+	},
+}
+
+func init() {
+	addRequeryFlags(requeryCmd, &requeryOpts)
+	requeryCmd.Flags().StringVar(&requeryOpts.dest, "dest", "", "Directory to write results to")
+	requeryCmd.MarkFlagsMutuallyExclusive("dest", "merge")
+	rootCmd.AddCommand(requeryCmd)
+}
+
+// addRequeryFlags adds the flags selecting queries and the merge flag to cmd
+func addRequeryFlags(cmd *cobra.Command, opts *requeryOptions) {
+	cmd.Flags().StringVar(&opts.querySet, "query-set", "", "Query set or catalog to requery. Defaults to the query set recorded in the manifest, or subset")
+	cmd.Flags().StringSliceVar(&opts.queries, "queries", nil, "Names or globs of the queries to requery, e.g. '*_rate_1m0s'. Defaults to every query of the query set")
+	cmd.Flags().BoolVar(&opts.merge, "merge", false, "Write results into the source directory and merge them into its manifest")
+}
+
+// requery requeries the run stored in source and returns the exit status of the command
+func requery(cmd *cobra.Command, config *domain.Config, source string, opts requeryOptions) int {
+	report, skipped, err := requeryRun(cmd, config, source, opts)
+	switch {
+	case err != nil:
+		slog.Error("Failed to requery stored run", "source", source, "err", err)
+		return 1
+	case skipped:
+		slog.Info("Requery already complete.", "source", source, "dest", config.S3BucketDir)
+		return 0
+	case logReport(report, config.FailurePolicy):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// requeryRun requeries the run stored in source and returns its report.
+// config is updated with the recorded window and the destination.
+// skipped is true when the run was skipped because the destination was already complete and the config does not force it.
+func requeryRun(cmd *cobra.Command, config *domain.Config, source string, opts requeryOptions) (report *domain.RunReport, skipped bool, err error) {
+	config.S3BucketDir = source
+	manifest, err := loadStoredRun(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	querySet := opts.querySet
+	if querySet == "" {
+		querySet = "subset"
+		if manifest != nil && manifest.QuerySet != "" {
+			querySet = manifest.QuerySet
+		}
+	}
+
+	switch {
+	case opts.merge:
+		config.S3BucketDir = source
+	case opts.dest != "":
+		config.S3BucketDir = opts.dest
+	default:
+		config.S3BucketDir = requeryDir(source)
+	}
+
+	prometheusAdapter, err := usecases.NewQuerySetAdapter(config, querySet)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := usecases.SelectQueries(prometheusAdapter, opts.queries); err != nil {
+		return nil, false, err
+	}
+	if err := prometheusAdapter.Validate(); err != nil {
+		return nil, false, fmt.Errorf("invalid queries, %w", err)
+	}
+	storageAdapter, err := usecases.OpenStorageAdapter(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !config.Force && isComplete(storageAdapter, prometheusAdapter) {
+		slog.Info("Destination already complete. Skipping.", "source", source, "dest", config.S3BucketDir)
+		return nil, true, nil
+	}
+	slog.Info("Requerying stored run.", "source", source, "dest", config.S3BucketDir, "querySet", querySet, "start", config.StartTime(), "end", config.EndTime)
+
+	processor := core.NewMetricsProcessor(prometheusAdapter, storageAdapter, runInfo(cmd, querySet, config))
+	if opts.merge && manifest != nil {
+		processor.MergeManifest(manifest)
+	}
+	return processor.Process(), false, nil
+}
+
+// isComplete reports whether the manifest at the destination records every registered query as stored
+// for the same query text and range
+func isComplete(destination port.MetricsStoragePort, query port.MetricsQueryPort) bool {
+	manifest, err := destination.ReadManifest()
+	if err != nil {
+		return false
+	}
+	for _, planned := range query.Plan() {
+		if !manifest.Completed(planned.Name, planned.Fingerprint) {
+			return false
+		}
+	}
+	return true
+}
+
+// loadStoredRun applies the query range recorded by the run stored in config.S3BucketDir to config.
+// It returns the manifest of the run, or nil for runs stored before manifests were introduced.
+func loadStoredRun(config *domain.Config) (*domain.Manifest, error) {
+	source, err := usecases.OpenStorageAdapter(config)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := source.ReadManifest()
+	if err == nil {
+		if err := manifest.ApplyTo(config); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	}
+	slog.Warn("Unable to read manifest. Falling back to the first stored result.", "dir", config.S3BucketDir, "error", err)
+
+	end, err := source.ParseEndTime()
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing end time, %w", err)
+	}
+	config.EndTime = end
+	config.Windows = nil
+	return nil, nil
+}
+
+// requeryDir appends -requery to the first path segment of dir
+func requeryDir(dir string) string {
+	parts := strings.Split(dir, "/")
+	parts[0] = parts[0] + "-requery"
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus/prometheustest"
+	"github.com/stretchr/testify/assert"
+)
+
+const invalidCatalog = `
+filter_sets:
+  base:
+    matchers:
+      - {label: namespace, op: "=", value: "{{ .Namespace }}"}
+groups:
+  - queries:
+      - name: up
+        expr:
+          - metric: up
+          - filter: {set: base}
+      - name: bad_matcher
+        expr:
+          - metric: up
+          - filter: {set: base, with: [{label: pod, op: "==", value: a}]}
+`
+
+func TestRequeryRunInvalidQueries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	}))
+	t.Cleanup(server.Close)
+
+	catalogPath := filepath.Join(t.TempDir(), "catalog.yaml")
+	if err := os.WriteFile(catalogPath, []byte(invalidCatalog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := &domain.Config{
+		MetricsQueryEndpoint: server.URL,
+		StorageBackend:       domain.StorageBackendFilesystem,
+		StorageRoot:          t.TempDir(),
+		S3BucketDir:          "exp/run-1",
+	}
+	end := time.Date(2021, 1, 1, 1, 0, 0, 0, time.UTC)
+	manifest := &domain.Manifest{
+		QuerySet: catalogPath,
+		Start:    end.Add(-time.Hour),
+		End:      end,
+		Config:   domain.ManifestConfig{Step: "15s", Namespace: "ns"},
+	}
+	assert.NoError(t, usecases.NewStorageAdapter(config).WriteManifest(manifest))
+
+	report, skipped, err := requeryRun(requeryCmd, config, "exp/run-1", requeryOptions{})
+
+	assert.ErrorContains(t, err, `query "bad_matcher": invalid operator "=="`)
+	assert.NotContains(t, err.Error(), `query "up"`)
+	assert.Nil(t, report)
+	assert.False(t, skipped)
+	// no query reached Prometheus, including the valid one
+	assert.Zero(t, requests.Load())
+}
+
+func TestRequeryTwice(t *testing.T) {
+	api := prometheustest.NewAPI()
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	catalogPath := filepath.Join(t.TempDir(), "catalog.yaml")
+	if err := os.WriteFile(catalogPath, []byte("groups:\n  - queries:\n      - {name: up, expr: [{metric: up}]}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := &domain.Config{
+		MetricsQueryEndpoint: server.URL,
+		StorageBackend:       domain.StorageBackendFilesystem,
+		StorageRoot:          t.TempDir(),
+		S3BucketDir:          "exp/run-1",
+	}
+	end := time.Date(2021, 1, 1, 1, 0, 0, 0, time.UTC)
+	manifest := &domain.Manifest{
+		QuerySet: catalogPath,
+		Start:    end.Add(-time.Hour),
+		End:      end,
+		Config:   domain.ManifestConfig{Step: "15s", Namespace: "ns"},
+	}
+	assert.NoError(t, usecases.NewStorageAdapter(config).WriteManifest(manifest))
+
+	first := *config
+	assert.Equal(t, 0, requery(requeryCmd, &first, "exp/run-1", requeryOptions{}))
+	requests := len(api.Requests())
+	assert.NotZero(t, requests)
+
+	// the destination is complete, so the second run is skipped without querying
+	second := *config
+	assert.Equal(t, 0, requery(requeryCmd, &second, "exp/run-1", requeryOptions{}))
+	assert.Len(t, api.Requests(), requests)
+}
@@ -1,8 +1,11 @@
 package commands
 
 import (
+	"log/slog"
 	"os"
 
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/config"
 	"github.com/spf13/cobra"
 )
 
@@ -25,12 +28,19 @@ func Execute() {
 	}
 }
 
-func init() {
-	// Here you will define your flags and configuration settings.
-	// Cobra supports persistent flags, which, if defined here,
-	// will be global for your application.
+// loadConfig resolves the config of cmd from flags, environment variables and the config file
+func loadConfig(cmd *cobra.Command) *domain.Config {
+	config, err := config.NewLoader(cmd.Flags()).Load()
+	if err != nil {
+		slog.Error("Failed to load config", "err", err)
+		os.Exit(1)
+	}
+	return config
+}
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.hexctl.yaml)")
+func init() {
+	// Every setting can be given as a flag, an environment variable or in the config file.
+	config.RegisterFlags(rootCmd.PersistentFlags())
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [QUERY_SET|CATALOG]...",
+	Short: "Validate queries without querying Prometheus",
+	Long: fmt.Sprintf(`Validate every query of the given query sets or catalogs.
+QUERY_SET is one of %s. Any other argument is treated as a catalog.
+All query sets are validated when no argument is given.`, strings.Join(usecases.QuerySetNames(), ", ")),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			args = usecases.QuerySetNames()
+		}
+
+		config := loadConfig(cmd)
+		failed := false
+		for _, name := range args {
+			prometheusAdapter := usecases.QuerySetAdapter(config, name)
+			if err := prometheusAdapter.Validate(); err != nil {
+				failed = true
+				for _, line := range strings.Split(err.Error(), "\n") {
+					fmt.Printf("%s: %s\n", name, line)
+				}
+				continue
+			}
+			slog.Info("Queries valid.", "querySet", name, "count", prometheusAdapter.Len())
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
+
+// exitOnInvalidQueries logs every problem of the registered queries and exits with non-zero status,
+// so that invalid PromQL is never sent to Prometheus
+func exitOnInvalidQueries(prometheusAdapter *prometheus.PrometheusAdapter) {
+	if err := prometheusAdapter.Validate(); err != nil {
+		slog.Error("Invalid queries. Run validate for details.", "err", err)
+		os.Exit(1)
+	}
+}
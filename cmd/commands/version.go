@@ -0,0 +1,24 @@
+package commands
+
+import (
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/spf13/cobra"
+)
+
+// Version is the version of the tool recorded in run manifests.
+// Set at build time with -ldflags "-X github.com/hanapedia/metrics-processor/cmd/commands.Version=<version>"
+var Version = "dev"
+
+// runInfo describes the run of cmd for the manifest
+func runInfo(cmd *cobra.Command, querySet string, config *domain.Config) domain.RunInfo {
+	return domain.RunInfo{
+		Command:  cmd.Name(),
+		QuerySet: querySet,
+		Version:  Version,
+		Config:   config,
+	}
+}
+
+func init() {
+	rootCmd.Version = Version
+}
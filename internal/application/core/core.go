@@ -2,6 +2,7 @@ package core
 
 import (
 	"log/slog"
+	"sync"
 
 	"github.com/hanapedia/metrics-processor/internal/application/port"
 	"github.com/hanapedia/metrics-processor/internal/domain"
@@ -10,20 +11,135 @@ import (
 type MetricsProcessor struct {
 	query   port.MetricsQueryPort
 	storage port.MetricsStoragePort
+	run     domain.RunInfo
+	// base is merged with the manifest of the run when set
+	base *domain.Manifest
+	// manifestMu serializes manifest writes of concurrent uploaders
+	manifestMu sync.Mutex
 }
 
-func NewMetricsProcessor(query port.MetricsQueryPort, storage port.MetricsStoragePort) *MetricsProcessor {
+func NewMetricsProcessor(query port.MetricsQueryPort, storage port.MetricsStoragePort, run domain.RunInfo) *MetricsProcessor {
 	return &MetricsProcessor{
 		query:   query,
 		storage: storage,
+		run:     run,
 	}
 }
 
-func (ms *MetricsProcessor) Process() {
-	metricsChan := make(chan *domain.MetricsMatrix, ms.query.Len())
-	ms.query.Query(metricsChan)
-	slog.Info("Metrics queried")
+// MergeManifest merges the manifest of the run into base instead of replacing it.
+// Use it when results are written into the directory of an existing run.
+func (ms *MetricsProcessor) MergeManifest(base *domain.Manifest) *MetricsProcessor {
+	ms.base = base
+	return ms
+}
+
+// Process runs the queries, stores the results and returns the report of the run.
+// Queries whose result is already stored for the same query text and range are skipped unless the config forces them.
+func (ms *MetricsProcessor) Process() *domain.RunReport {
+	report := domain.NewRunReport()
+	if ms.run.Config == nil || !ms.run.Config.Force {
+		ms.resume(report)
+	}
+	bufferSize, uploaders := ms.storageLimits()
+	metricsChan := make(chan *domain.MetricsMatrix, bufferSize)
+	ms.query.Query(metricsChan, report)
+
+	// queries block once the buffer is full, so only the results being stored and buffered are held in memory
+	var wg sync.WaitGroup
+	for range uploaders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for metricsMatrix := range metricsChan {
+				ms.save(metricsMatrix, report)
+			}
+		}()
+	}
+	wg.Wait()
+	slog.Info("Metrics queried and saved", "total", report.Total(), "skipped", report.Skipped(), "failed", report.Failed())
+
+	err := ms.writeManifest(report)
+	if err != nil {
+		slog.Error("Failed to write manifest", "err", err)
+	}
+	report.RecordManifest(err)
+
+	return report
+}
+
+// save stores a single result and checkpoints the manifest,
+// so that an interrupted run is resumed from the manifest without reading the stored results
+func (ms *MetricsProcessor) save(metricsMatrix *domain.MetricsMatrix, report *domain.RunReport) {
+	single := make(chan *domain.MetricsMatrix, 1)
+	single <- metricsMatrix
+	close(single)
+	ms.storage.Save(single, report)
+
+	if err := ms.writeManifest(report); err != nil {
+		slog.Warn("Failed to checkpoint manifest", "name", metricsMatrix.Name, "err", err)
+	}
+}
+
+// writeManifest writes the manifest of the results recorded in report so far
+func (ms *MetricsProcessor) writeManifest(report *domain.RunReport) error {
+	ms.manifestMu.Lock()
+	defer ms.manifestMu.Unlock()
 
-	ms.storage.Save(metricsChan)
-	slog.Info("Metrics saved")
+	manifest := domain.NewManifest(ms.run, report)
+	if ms.base != nil {
+		manifest = ms.base.Merge(manifest)
+	}
+	return ms.storage.WriteManifest(manifest)
+}
+
+// storageLimits returns the size of the buffer between queries and storage and the number of concurrent uploaders
+func (ms *MetricsProcessor) storageLimits() (bufferSize int, uploaders int) {
+	if ms.run.Config == nil {
+		return 0, 1
+	}
+	return max(0, ms.run.Config.StorageBufferSize), max(1, ms.run.Config.StorageUploaders)
+}
+
+// resume records the queries whose result is already stored with the same fingerprint as skipped and removes them from the run.
+// Stored results are looked up in the manifest, which is checkpointed after every stored result,
+// then in the stored objects for results stored after the last checkpoint or by runs without a manifest.
+func (ms *MetricsProcessor) resume(report *domain.RunReport) {
+	plan := ms.query.Plan()
+	stored := map[string]domain.ManifestQuery{}
+	if manifest, err := ms.storage.ReadManifest(); err == nil {
+		for _, query := range manifest.Queries {
+			stored[query.Name] = query
+		}
+	}
+
+	var missing []string
+	for _, planned := range plan {
+		if query, ok := stored[planned.Name]; !ok || query.Status != domain.QueryStatusOK {
+			missing = append(missing, planned.Name)
+		}
+	}
+	if len(missing) > 0 {
+		objects, err := ms.storage.ReadStored(missing)
+		if err != nil {
+			slog.Warn("Unable to read stored results. Running every missing query.", "err", err)
+		}
+		for _, query := range objects {
+			stored[query.Name] = query
+		}
+	}
+
+	var skip []string
+	for _, planned := range plan {
+		query, ok := stored[planned.Name]
+		if !ok || query.Status != domain.QueryStatusOK || query.Fingerprint == "" || query.Fingerprint != planned.Fingerprint {
+			continue
+		}
+		query.Query = planned.Query
+		report.RecordSkipped(query)
+		skip = append(skip, planned.Name)
+	}
+	if len(skip) > 0 {
+		ms.query.Skip(skip)
+		slog.Info("Resuming run. Skipping queries already stored.", "skipped", len(skip), "remaining", ms.query.Len())
+	}
 }
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQuery struct {
+	plan []domain.QueryResult
+	ran  []string
+}
+
+func (f *fakeQuery) Query(metricsChan chan<- *domain.MetricsMatrix, report *domain.RunReport) {
+	for _, planned := range f.plan {
+		f.ran = append(f.ran, planned.Name)
+	}
+	go func() {
+		for _, planned := range f.plan {
+			report.RecordQuery(planned, nil)
+			metricsChan <- &domain.MetricsMatrix{Name: planned.Name, Fingerprint: planned.Fingerprint}
+		}
+		close(metricsChan)
+	}()
+}
+
+func (f *fakeQuery) Len() int {
+	return len(f.plan)
+}
+
+func (f *fakeQuery) Plan() []domain.QueryResult {
+	return f.plan
+}
+
+func (f *fakeQuery) Skip(names []string) {
+	f.plan = slices.DeleteFunc(f.plan, func(planned domain.QueryResult) bool {
+		return slices.Contains(names, planned.Name)
+	})
+}
+
+type fakeStorage struct {
+	mu       sync.Mutex
+	manifest *domain.Manifest
+	objects  map[string]domain.ManifestQuery
+	// manifestErr fails WriteManifest when set
+	manifestErr error
+	// written records every manifest written, in order
+	written []*domain.Manifest
+}
+
+func (f *fakeStorage) Save(metricsChan <-chan *domain.MetricsMatrix, report *domain.RunReport) {
+	for metricsMatrix := range metricsChan {
+		key := "dir/" + metricsMatrix.Name + ".json"
+		f.mu.Lock()
+		f.objects[metricsMatrix.Name] = domain.ManifestQuery{Name: metricsMatrix.Name, Key: key, Fingerprint: metricsMatrix.Fingerprint, Status: domain.QueryStatusOK}
+		f.mu.Unlock()
+		report.RecordSave(metricsMatrix.Name, domain.SavedObject{Key: key}, nil)
+	}
+}
+
+func (f *fakeStorage) WriteManifest(manifest *domain.Manifest) error {
+	if f.manifestErr != nil {
+		return f.manifestErr
+	}
+	f.manifest = manifest
+	f.written = append(f.written, manifest)
+	return nil
+}
+
+func (f *fakeStorage) ReadManifest() (*domain.Manifest, error) {
+	if f.manifest == nil {
+		return nil, errors.New("no manifest")
+	}
+	return f.manifest, nil
+}
+
+func (f *fakeStorage) ReadStored(names []string) ([]domain.ManifestQuery, error) {
+	var stored []domain.ManifestQuery
+	for _, name := range names {
+		if query, ok := f.objects[name]; ok {
+			stored = append(stored, query)
+		}
+	}
+	return stored, nil
+}
+
+func newPlan() []domain.QueryResult {
+	return []domain.QueryResult{
+		{Name: "a", Query: "up", Fingerprint: "sha256:a"},
+		{Name: "b", Query: "up", Fingerprint: "sha256:b"},
+		{Name: "c", Query: "up", Fingerprint: "sha256:c"},
+	}
+}
+
+func TestProcessResume(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest *domain.Manifest
+		objects  map[string]domain.ManifestQuery
+		force    bool
+		expected []string
+	}{
+		{
+			name:     "nothing stored",
+			objects:  map[string]domain.ManifestQuery{},
+			expected: []string{"a", "b", "c"},
+		},
+		{
+			name: "manifest",
+			manifest: &domain.Manifest{Queries: []domain.ManifestQuery{
+				{Name: "a", Fingerprint: "sha256:a", Status: domain.QueryStatusOK},
+				{Name: "b", Fingerprint: "sha256:changed", Status: domain.QueryStatusOK},
+				{Name: "c", Fingerprint: "sha256:c", Status: domain.QueryStatusQueryFailed},
+			}},
+			objects:  map[string]domain.ManifestQuery{},
+			expected: []string{"b", "c"},
+		},
+		{
+			name: "interrupted before the manifest was written",
+			objects: map[string]domain.ManifestQuery{
+				"a": {Name: "a", Fingerprint: "sha256:a", Status: domain.QueryStatusOK},
+				"b": {Name: "b", Status: domain.QueryStatusOK},
+			},
+			expected: []string{"b", "c"},
+		},
+		{
+			name: "force",
+			objects: map[string]domain.ManifestQuery{
+				"a": {Name: "a", Fingerprint: "sha256:a", Status: domain.QueryStatusOK},
+			},
+			force:    true,
+			expected: []string{"a", "b", "c"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := &fakeQuery{plan: newPlan()}
+			storage := &fakeStorage{manifest: tt.manifest, objects: tt.objects}
+			run := domain.RunInfo{Config: &domain.Config{Force: tt.force, StorageUploaders: 2}}
+
+			report := NewMetricsProcessor(query, storage, run).Process()
+			assert.Equal(t, tt.expected, query.ran)
+			assert.Equal(t, 3, report.Total())
+			assert.Equal(t, 0, report.Failed())
+			assert.Equal(t, 3-len(tt.expected), report.Skipped())
+
+			// the manifest records skipped queries so that the next run skips them too
+			for _, planned := range newPlan() {
+				assert.True(t, storage.manifest.Completed(planned.Name, planned.Fingerprint), planned.Name)
+			}
+		})
+	}
+}
+
+func TestProcessManifestFailure(t *testing.T) {
+	query := &fakeQuery{plan: newPlan()}
+	storage := &fakeStorage{objects: map[string]domain.ManifestQuery{}, manifestErr: errors.New("upload failed")}
+	run := domain.RunInfo{Config: &domain.Config{}}
+
+	report := NewMetricsProcessor(query, storage, run).Process()
+	assert.Equal(t, 0, report.Failed())
+	assert.EqualError(t, report.ManifestError(), "upload failed")
+	assert.True(t, report.ShouldFail(domain.FailurePolicy{Mode: domain.FailOnAny}))
+	assert.True(t, report.ShouldFail(domain.FailurePolicy{Mode: domain.FailAboveThreshold, ThresholdPercent: 50}))
+	assert.False(t, report.ShouldFail(domain.FailurePolicy{Mode: domain.FailNever}))
+}
+
+func TestProcessCheckpointsManifest(t *testing.T) {
+	query := &fakeQuery{plan: newPlan()}
+	storage := &fakeStorage{objects: map[string]domain.ManifestQuery{}}
+	run := domain.RunInfo{Config: &domain.Config{}}
+
+	NewMetricsProcessor(query, storage, run).Process()
+
+	// one checkpoint after each stored result, then the final manifest
+	assert.Len(t, storage.written, 4)
+	for i, manifest := range storage.written[:3] {
+		completed := 0
+		for _, planned := range newPlan() {
+			if manifest.Completed(planned.Name, planned.Fingerprint) {
+				completed++
+			}
+		}
+		assert.Equal(t, i+1, completed)
+	}
+}
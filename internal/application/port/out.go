@@ -1,18 +1,54 @@
 package port
 
 import (
+	"time"
+
 	"github.com/hanapedia/metrics-processor/internal/domain"
 )
 
 // MetricsQueryPort represents port for querying metrics from arbitrary backend
 type MetricsQueryPort interface {
-	// Query run the registered queries
-	Query(chan<- *domain.MetricsMatrix)
+	// Query starts running the registered queries without waiting for them, records their outcome in the report
+	// and closes the channel once every result was sent
+	Query(chan<- *domain.MetricsMatrix, *domain.RunReport)
 	// Len gets the number of registered queries
 	Len() int
+	// Plan describes the registered queries with the fingerprint of their query text and range
+	Plan() []domain.QueryResult
+	// Skip removes the named queries from the run
+	Skip(names []string)
 }
 
 // MetricsStoragePort represents port for storing metrics to arbitrary backend
 type MetricsStoragePort interface {
-	Save(<-chan *domain.MetricsMatrix)
+	// Save stores the metrics until the channel is closed and records the outcome in the report.
+	// It is called from several goroutines sharing the channel.
+	Save(<-chan *domain.MetricsMatrix, *domain.RunReport)
+	// WriteManifest stores the manifest of the run next to the metrics
+	WriteManifest(*domain.Manifest) error
+	// ReadManifest reads the manifest stored by a previous run
+	ReadManifest() (*domain.Manifest, error)
+	// ReadStored reads the results of the named queries stored by a previous run.
+	// Queries without a stored result are left out.
+	ReadStored(names []string) ([]domain.ManifestQuery, error)
+}
+
+// MetricsSourcePort represents port for reading stored metrics from arbitrary backend
+type MetricsSourcePort interface {
+	// ParseEndTime reads the end time of the stored query range from the first stored result
+	ParseEndTime() (time.Time, error)
+	// ReadManifest reads the manifest of the stored run
+	ReadManifest() (*domain.Manifest, error)
+	// ListRuns lists the directories under the configured directory that contain stored results, in lexical order
+	ListRuns() ([]string, error)
+}
+
+// MetricsMigrationPort represents port for rewriting stored metrics in place
+type MetricsMigrationPort interface {
+	// ListResults lists the keys of the results stored in the configured directory, in lexical order
+	ListResults() ([]string, error)
+	// ReadResult reads the result stored under key as stored
+	ReadResult(key string) ([]byte, error)
+	// WriteResult replaces the result stored under key
+	WriteResult(key string, data []byte) error
 }
@@ -0,0 +1,295 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases/query"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/pkg/promql"
+)
+
+// templateData is passed to every template in the catalog
+type templateData struct {
+	*domain.Config
+	// Rate is the rate config of the current expansion. Zero value outside per_rate groups.
+	Rate query.RateConfig
+	// RateSuffix is the suffix added to query names by query.RateConfig.AddSuffix, e.g. "_rate_5m0s"
+	RateSuffix string
+}
+
+// Build renders every query in the catalog for the given config
+func (c *Catalog) Build(config *domain.Config) ([]*promql.Query, error) {
+	rateConfigs, err := c.resolveRateConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	data := templateData{Config: config}
+	var perRateGroups []Group
+	var queries []*promql.Query
+
+	// non per_rate groups are registered first, then per_rate groups for each rate config
+	for _, group := range c.Groups {
+		enabled, err := evalWhen(group.When, data)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %w", group.Name, err)
+		}
+		if !enabled {
+			continue
+		}
+		if group.PerRate {
+			perRateGroups = append(perRateGroups, group)
+			continue
+		}
+		built, err := c.buildGroup(group, data)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, built...)
+	}
+
+	for _, rateConfig := range rateConfigs {
+		data := templateData{
+			Config:     config,
+			Rate:       rateConfig,
+			RateSuffix: rateConfig.AddSuffix(""),
+		}
+		for _, group := range perRateGroups {
+			built, err := c.buildGroup(group, data)
+			if err != nil {
+				return nil, err
+			}
+			queries = append(queries, built...)
+		}
+	}
+
+	return queries, nil
+}
+
+func (c *Catalog) resolveRateConfigs(config *domain.Config) ([]query.RateConfig, error) {
+	var rateConfigs []query.RateConfig
+	for _, rc := range c.RateConfigs {
+		duration, err := resolveDuration(rc.Duration, rc.StepMultiple, config.Step)
+		if err != nil {
+			return nil, fmt.Errorf("rate config %q: %w", rc.Name, err)
+		}
+		rateConfigs = append(rateConfigs, query.RateConfig{
+			Name:      rc.Name,
+			Duration:  duration,
+			IsInstant: rc.Instant,
+		})
+	}
+	return rateConfigs, nil
+}
+
+func (c *Catalog) buildGroup(group Group, data templateData) ([]*promql.Query, error) {
+	var queries []*promql.Query
+	for _, entry := range group.Queries {
+		name, err := render(entry.Name, data)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %w", group.Name, err)
+		}
+		q, err := c.buildExpr(entry.Expr, data)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %w", name, err)
+		}
+		if entry.Timeout != "" {
+			timeout, err := time.ParseDuration(entry.Timeout)
+			if err != nil {
+				return nil, fmt.Errorf("query %q: invalid timeout, %w", name, err)
+			}
+			q.SetTimeout(timeout)
+		}
+		if entry.Retries != nil {
+			q.SetRetries(*entry.Retries)
+		}
+		mode, err := promql.ParseMode(entry.Mode)
+		if err != nil {
+			return nil, fmt.Errorf("query %q: %w", name, err)
+		}
+		q.SetPriority(entry.Priority)
+		q.SetUnit(entry.Unit)
+		q.SetMode(mode)
+		queries = append(queries, q.SetName(name))
+	}
+	return queries, nil
+}
+
+// buildExpr applies steps in order. The first step must be metric or number.
+func (c *Catalog) buildExpr(steps []Step, data templateData) (*promql.Query, error) {
+	if len(steps) == 0 {
+		return nil, errors.New("empty expression")
+	}
+	for i, step := range steps {
+		if fields := step.fields(); len(fields) != 1 {
+			return nil, fmt.Errorf("step %d sets %v, exactly one field must be set", i, fields)
+		}
+	}
+
+	var q *promql.Query
+	switch {
+	case steps[0].Metric != "":
+		q = promql.NewQuery(steps[0].Metric)
+	case steps[0].Number != "":
+		q = promql.NewQuery(steps[0].Number)
+	default:
+		return nil, errors.New("expression must start with metric or number")
+	}
+
+	for i, step := range steps[1:] {
+		if err := c.applyStep(q, step, data); err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return q, nil
+}
+
+func (c *Catalog) applyStep(q *promql.Query, step Step, data templateData) error {
+	switch {
+	case step.Filter != nil:
+		filters, err := c.resolveFilters(step.Filter, data)
+		if err != nil {
+			return err
+		}
+		q.Filter(filters)
+	case step.Offset != "":
+		offset, err := time.ParseDuration(step.Offset)
+		if err != nil {
+			return fmt.Errorf("invalid offset, %w", err)
+		}
+		q.Offset(offset)
+	case step.Rate != nil:
+		if step.Rate.Duration == "" && step.Rate.StepMultiple == 0 {
+			if data.Rate.Duration == 0 {
+				return errors.New("rate without duration outside per_rate group")
+			}
+			if data.Rate.IsInstant {
+				q.IRate(data.Rate.Duration)
+			} else {
+				q.Rate(data.Rate.Duration)
+			}
+			return nil
+		}
+		duration, err := resolveDuration(step.Rate.Duration, step.Rate.StepMultiple, data.Step)
+		if err != nil {
+			return err
+		}
+		q.Rate(duration)
+	case step.SumBy != nil:
+		q.SumBy(step.SumBy)
+	case step.MinBy != nil:
+		q.MinBy(step.MinBy)
+	case step.HistogramQuantile != 0:
+		q.HistogramQuantile(step.HistogramQuantile)
+	case step.Multiply != 0:
+		q.MultiplyByConstant(step.Multiply)
+	case step.Group:
+		q.Group()
+	case step.Divide != nil:
+		rhs, err := c.buildExpr(step.Divide, data)
+		if err != nil {
+			return fmt.Errorf("divide: %w", err)
+		}
+		q.Divide(rhs)
+	case step.Subtract != nil:
+		rhs, err := c.buildExpr(step.Subtract, data)
+		if err != nil {
+			return fmt.Errorf("subtract: %w", err)
+		}
+		q.Subtract(rhs)
+	default:
+		return fmt.Errorf("unsupported step %v", step.fields())
+	}
+	return nil
+}
+
+func (c *Catalog) resolveFilters(ref *FilterRef, data templateData) ([]promql.Filter, error) {
+	var matchers []Matcher
+	if ref.Set != "" {
+		set, err := c.resolveFilterSet(ref.Set, nil)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, set...)
+	}
+	matchers = append(matchers, ref.With...)
+
+	filters := make([]promql.Filter, 0, len(matchers))
+	for _, matcher := range matchers {
+		value, err := render(matcher.Value, data)
+		if err != nil {
+			return nil, fmt.Errorf("matcher %q: %w", matcher.Label, err)
+		}
+		filters = append(filters, promql.NewFilter(matcher.Label, matcher.Operator, value))
+	}
+	return filters, nil
+}
+
+// resolveFilterSet returns the matchers of the named set after those of the sets it extends.
+// path lists the sets extending it, to report cycles.
+func (c *Catalog) resolveFilterSet(name string, path []string) ([]Matcher, error) {
+	if i := slices.Index(path, name); i >= 0 {
+		return nil, fmt.Errorf("filter set %q extends itself through %s", name, strings.Join(append(path[i:], name), " -> "))
+	}
+	path = append(path, name)
+
+	set, ok := c.FilterSets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter set %q", name)
+	}
+	if set.Extends == "" {
+		return set.Matchers, nil
+	}
+	parent, err := c.resolveFilterSet(set.Extends, path)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]Matcher{}, parent...), set.Matchers...), nil
+}
+
+func resolveDuration(duration string, stepMultiple int, step time.Duration) (time.Duration, error) {
+	if stepMultiple != 0 {
+		return step * time.Duration(stepMultiple), nil
+	}
+	parsed, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration, %w", err)
+	}
+	return parsed, nil
+}
+
+func evalWhen(when string, data templateData) (bool, error) {
+	if when == "" {
+		return true, nil
+	}
+	rendered, err := render(when, data)
+	if err != nil {
+		return false, err
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(rendered))
+	if err != nil {
+		return false, fmt.Errorf("when must render to a boolean, %w", err)
+	}
+	return enabled, nil
+}
+
+func render(text string, data templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %q, %w", text, err)
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to render %q, %w", text, err)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,41 @@
+package catalog
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+//go:embed builtin/*.yaml
+var builtinFiles embed.FS
+
+// builtinFS holds the built-in catalogs under builtin/
+var builtinFS fs.FS = builtinFiles
+
+// Builtin loads the built-in catalog with the given name
+func Builtin(name string) (*Catalog, error) {
+	file, err := builtinFS.Open(path.Join("builtin", name+".yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("No built-in catalog named %q", name)
+	}
+	defer file.Close()
+	catalog, err := Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("built-in catalog %q: %w", name, err)
+	}
+	return catalog, nil
+}
+
+// BuiltinNames lists the names of the built-in catalogs
+func BuiltinNames() []string {
+	entries, _ := fs.ReadDir(builtinFS, "builtin")
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,159 @@
+package catalog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Catalog describes a set of queries declaratively.
+// Catalogs are written in YAML. Since JSON is a subset of YAML, JSON catalogs are accepted as well.
+type Catalog struct {
+	Name        string               `yaml:"name" json:"name"`
+	RateConfigs []RateConfig         `yaml:"rate_configs" json:"rate_configs"`
+	FilterSets  map[string]FilterSet `yaml:"filter_sets" json:"filter_sets"`
+	Groups      []Group              `yaml:"groups" json:"groups"`
+}
+
+// RateConfig describes a rate window that per_rate groups are expanded over.
+// Either Duration or StepMultiple must be set.
+type RateConfig struct {
+	Name     string `yaml:"name" json:"name"`
+	Duration string `yaml:"duration" json:"duration"`
+	// StepMultiple sets the window relative to the configured step
+	StepMultiple int `yaml:"step_multiple" json:"step_multiple"`
+	// Instant selects irate instead of rate
+	Instant bool `yaml:"instant" json:"instant"`
+}
+
+// FilterSet is a named list of matchers, optionally extending another set
+type FilterSet struct {
+	Extends  string    `yaml:"extends" json:"extends"`
+	Matchers []Matcher `yaml:"matchers" json:"matchers"`
+}
+
+// Matcher describes a single label matcher. Value is a text/template rendered with the run config.
+type Matcher struct {
+	Label    string `yaml:"label" json:"label"`
+	Operator string `yaml:"op" json:"op"`
+	Value    string `yaml:"value" json:"value"`
+}
+
+// Group is a list of queries sharing expansion rules.
+type Group struct {
+	Name string `yaml:"name" json:"name"`
+	// PerRate expands every query in the group once for each rate config
+	PerRate bool `yaml:"per_rate" json:"per_rate"`
+	// When is a text/template that must render to a boolean. The group is skipped when false.
+	When    string  `yaml:"when" json:"when"`
+	Queries []Entry `yaml:"queries" json:"queries"`
+}
+
+// Entry describes a single query.
+// Name is a text/template rendered with the run config and the current rate config.
+type Entry struct {
+	Name string `yaml:"name" json:"name"`
+	Expr []Step `yaml:"expr" json:"expr"`
+	// Timeout and Retries override the settings of the query adapter for this query
+	Timeout string `yaml:"timeout" json:"timeout"`
+	Retries *int   `yaml:"retries" json:"retries"`
+	// Priority orders the query before dispatch. Higher runs first.
+	Priority int `yaml:"priority" json:"priority"`
+	// Unit of the result values, stored with the result
+	Unit string `yaml:"unit" json:"unit"`
+	// Mode is range, the default, or instant to evaluate the query once at the end of the query range
+	Mode string `yaml:"mode" json:"mode"`
+}
+
+// Step is a single builder call on a query. Exactly one field must be set.
+// Steps are applied in order, mirroring the fluent API of promql.Query.
+type Step struct {
+	Metric            string     `yaml:"metric" json:"metric"`
+	Number            string     `yaml:"number" json:"number"`
+	Filter            *FilterRef `yaml:"filter" json:"filter"`
+	Offset            string     `yaml:"offset" json:"offset"`
+	Rate              *RateStep  `yaml:"rate" json:"rate"`
+	SumBy             []string   `yaml:"sum_by" json:"sum_by"`
+	MinBy             []string   `yaml:"min_by" json:"min_by"`
+	HistogramQuantile float32    `yaml:"histogram_quantile" json:"histogram_quantile"`
+	Multiply          int        `yaml:"multiply" json:"multiply"`
+	Group             bool       `yaml:"group" json:"group"`
+	Divide            []Step     `yaml:"divide" json:"divide"`
+	Subtract          []Step     `yaml:"subtract" json:"subtract"`
+}
+
+// fields returns the names of the fields set on the step
+func (s Step) fields() []string {
+	var fields []string
+	set := map[string]bool{
+		"metric":             s.Metric != "",
+		"number":             s.Number != "",
+		"filter":             s.Filter != nil,
+		"offset":             s.Offset != "",
+		"rate":               s.Rate != nil,
+		"sum_by":             s.SumBy != nil,
+		"min_by":             s.MinBy != nil,
+		"histogram_quantile": s.HistogramQuantile != 0,
+		"multiply":           s.Multiply != 0,
+		"group":              s.Group,
+		"divide":             s.Divide != nil,
+		"subtract":           s.Subtract != nil,
+	}
+	for name, ok := range set {
+		if ok {
+			fields = append(fields, name)
+		}
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// FilterRef selects a filter set and optionally appends extra matchers to it
+type FilterRef struct {
+	Set  string    `yaml:"set" json:"set"`
+	With []Matcher `yaml:"with" json:"with"`
+}
+
+// RateStep applies rate or irate.
+// When neither Duration nor StepMultiple is set, the rate config of the current expansion is used.
+type RateStep struct {
+	Duration     string `yaml:"duration" json:"duration"`
+	StepMultiple int    `yaml:"step_multiple" json:"step_multiple"`
+}
+
+// Load reads a catalog from r
+func Load(r io.Reader) (*Catalog, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	var catalog Catalog
+	if err := decoder.Decode(&catalog); err != nil {
+		return nil, fmt.Errorf("Failed to decode catalog, %w", err)
+	}
+	return &catalog, nil
+}
+
+// LoadFile reads a catalog from the file at path
+func LoadFile(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read catalog %q, %w", path, err)
+	}
+	catalog, err := Load(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return catalog, nil
+}
+
+// Open resolves name to a built-in catalog, or to a catalog file if no built-in catalog matches
+func Open(name string) (*Catalog, error) {
+	if slices.Contains(BuiltinNames(), name) {
+		return Builtin(name)
+	}
+	return LoadFile(name)
+}
@@ -0,0 +1,179 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog string
+		wantErr string
+	}{
+		{
+			name:    "valid catalog",
+			catalog: "name: test\ngroups:\n  - name: g\n    queries:\n      - {name: up, expr: [{metric: up}]}\n",
+		},
+		{
+			name:    "unknown field",
+			catalog: "name: test\ngroup:\n  - name: g\n",
+			wantErr: "field group not found",
+		},
+		{
+			name:    "unknown step field",
+			catalog: "name: test\ngroups:\n  - name: g\n    queries:\n      - {name: up, expr: [{metrik: up}]}\n",
+			wantErr: "field metrik not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(strings.NewReader(tt.catalog))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	upGroup := "  - name: g\n    queries:\n      - {name: up, expr: [{metric: up}, {filter: {set: a}}]}\n"
+	tests := []struct {
+		name      string
+		catalog   string
+		wantNames []string
+		wantQuery string
+		wantErr   string
+	}{
+		{
+			name:      "filter set extending another",
+			catalog:   "filter_sets:\n  a: {extends: b, matchers: [{label: pod, op: '=', value: x}]}\n  b: {matchers: [{label: namespace, op: '=', value: '{{ .Namespace }}'}]}\ngroups:\n" + upGroup,
+			wantNames: []string{"up"},
+			wantQuery: `up{namespace="ns",pod="x"}`,
+		},
+		{
+			name:    "unknown filter set",
+			catalog: "groups:\n" + upGroup,
+			wantErr: `unknown filter set "a"`,
+		},
+		{
+			name:    "filter set extending itself",
+			catalog: "filter_sets:\n  a: {extends: a}\ngroups:\n" + upGroup,
+			wantErr: `filter set "a" extends itself through a -> a`,
+		},
+		{
+			name:    "filter sets extending each other",
+			catalog: "filter_sets:\n  a: {extends: b}\n  b: {extends: c}\n  c: {extends: b}\ngroups:\n" + upGroup,
+			wantErr: `filter set "b" extends itself through b -> c -> b`,
+		},
+		{
+			name:      "when renders true",
+			catalog:   "groups:\n  - name: g\n    when: '{{ not .QueryTaskMetrics }}'\n    queries:\n      - {name: up, expr: [{metric: up}]}\n",
+			wantNames: []string{"up"},
+		},
+		{
+			name:      "when renders false",
+			catalog:   "groups:\n  - name: g\n    when: '{{ .QueryTaskMetrics }}'\n    queries:\n      - {name: up, expr: [{metric: up}]}\n",
+			wantNames: nil,
+		},
+		{
+			name:    "when renders a non boolean",
+			catalog: "groups:\n  - name: g\n    when: '{{ .Namespace }}'\n    queries:\n      - {name: up, expr: [{metric: up}]}\n",
+			wantErr: "when must render to a boolean",
+		},
+		{
+			name:    "when references a missing field",
+			catalog: "groups:\n  - name: g\n    when: '{{ .Missing }}'\n    queries:\n      - {name: up, expr: [{metric: up}]}\n",
+			wantErr: `group "g"`,
+		},
+		{
+			name:      "name rendered per rate config",
+			catalog:   "rate_configs:\n  - {name: a, duration: 1m}\n  - {name: b, step_multiple: 8}\ngroups:\n  - name: g\n    per_rate: true\n    queries:\n      - {name: 'up{{ .RateSuffix }}', expr: [{metric: up}, {rate: {}}]}\n",
+			wantNames: []string{"up_rate_1m0s", "up_rate_2m0s"},
+		},
+		{
+			name:    "bad rate config duration",
+			catalog: "rate_configs:\n  - {name: a, duration: 5x}\ngroups: []\n",
+			wantErr: `rate config "a": invalid duration`,
+		},
+		{
+			name:    "bad rate step duration",
+			catalog: "groups:\n  - name: g\n    queries:\n      - {name: up, expr: [{metric: up}, {rate: {duration: 5x}}]}\n",
+			wantErr: `query "up": step 1: invalid duration`,
+		},
+		{
+			name:    "bad offset",
+			catalog: "groups:\n  - name: g\n    queries:\n      - {name: up, expr: [{metric: up}, {offset: 5x}]}\n",
+			wantErr: `query "up": step 1: invalid offset`,
+		},
+		{
+			name:    "bad timeout",
+			catalog: "groups:\n  - name: g\n    queries:\n      - {name: up, timeout: 5x, expr: [{metric: up}]}\n",
+			wantErr: `query "up": invalid timeout`,
+		},
+		{
+			name:    "step with several fields",
+			catalog: "groups:\n  - name: g\n    queries:\n      - {name: up, expr: [{metric: up}, {group: true, multiply: 2}]}\n",
+			wantErr: `query "up": step 1 sets [group multiply], exactly one field must be set`,
+		},
+		{
+			name:    "nested step with several fields",
+			catalog: "groups:\n  - name: g\n    queries:\n      - {name: up, expr: [{metric: up}, {divide: [{metric: a, number: '1'}]}]}\n",
+			wantErr: `query "up": step 1: divide: step 0 sets [metric number], exactly one field must be set`,
+		},
+		{
+			name:    "empty step",
+			catalog: "groups:\n  - name: g\n    queries:\n      - {name: up, expr: [{metric: up}, {}]}\n",
+			wantErr: `query "up": step 1 sets [], exactly one field must be set`,
+		},
+	}
+	config := &domain.Config{Step: 15 * time.Second, Namespace: "ns"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog, err := Load(strings.NewReader(tt.catalog))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			queries, err := catalog.Build(config)
+
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			var names []string
+			for _, q := range queries {
+				names = append(names, q.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+			if tt.wantQuery != "" {
+				assert.Equal(t, tt.wantQuery, queries[0].AsString())
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	embedded := builtinFS
+	t.Cleanup(func() { builtinFS = embedded })
+	builtinFS = fstest.MapFS{
+		"builtin/broken.yaml": {Data: []byte("name: broken\nunknown: true\n")},
+	}
+
+	_, err := Open("broken")
+	assert.ErrorContains(t, err, `built-in catalog "broken"`)
+	assert.ErrorContains(t, err, "field unknown not found")
+
+	_, err = Open("missing.yaml")
+	assert.ErrorContains(t, err, "missing.yaml")
+}
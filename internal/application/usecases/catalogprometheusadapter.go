@@ -0,0 +1,47 @@
+package usecases
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases/catalog"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus"
+)
+
+// CatalogPrometheusQueryAdapter creates prometheusAdapter with the queries described by a catalog
+func CatalogPrometheusQueryAdapter(config *domain.Config, queryCatalog *catalog.Catalog) *prometheus.PrometheusAdapter {
+	return exitOnAdapterError(newCatalogAdapter(config, queryCatalog))
+}
+
+func newCatalogAdapter(config *domain.Config, queryCatalog *catalog.Catalog) (*prometheus.PrometheusAdapter, error) {
+	prometheusAdapter, err := prometheus.NewPrometheusAdapter(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create new Prometheus adapter, %w", err)
+	}
+
+	queries, err := queryCatalog.Build(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build queries from catalog %q, %w", queryCatalog.Name, err)
+	}
+
+	for _, query := range queries {
+		prometheusAdapter.RegisterQuery(query)
+	}
+
+	if err := checkRateWindows(config, prometheusAdapter); err != nil {
+		return nil, err
+	}
+	return prometheusAdapter, nil
+}
+
+// OpenCatalog opens a built-in catalog or a catalog file
+func OpenCatalog(name string) *catalog.Catalog {
+	queryCatalog, err := catalog.Open(name)
+	if err != nil {
+		slog.Error("Failed to open catalog", "catalog", name, "err", err)
+		os.Exit(1)
+	}
+	return queryCatalog
+}
@@ -0,0 +1,51 @@
+package usecases
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases/catalog"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuiltinCatalogsMatchAdapters(t *testing.T) {
+	tests := []struct {
+		name      string
+		catalog   string
+		adapter   func(*domain.Config) *prometheus.PrometheusAdapter
+		queryTask bool
+	}{
+		{name: "hexagon", catalog: "hexagon", adapter: HexagonPrometheusQueryAdapter},
+		{name: "hexagon with task metrics", catalog: "hexagon", adapter: HexagonPrometheusQueryAdapter, queryTask: true},
+		{name: "default", catalog: "default", adapter: PrometheusQueryAdapter},
+		{name: "subset", catalog: "subset", adapter: SubsetPrometheusQueryAdapter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &domain.Config{
+				MetricsQueryEndpoint: "http://localhost:9090",
+				EndTime:              time.Unix(1609459200, 0),
+				Duration:             30 * time.Minute,
+				Step:                 15 * time.Second,
+				K6TestName:           "test",
+				Namespace:            "emulation",
+				WorkloadContainers:   "server|redis",
+				QueryTaskMetrics:     tt.queryTask,
+			}
+			queryCatalog, err := catalog.Builtin(tt.catalog)
+			assert.NoError(t, err)
+
+			expected := tt.adapter(config).Queries()
+			actual := CatalogPrometheusQueryAdapter(config, queryCatalog).Queries()
+
+			assert.Equal(t, len(expected), len(actual))
+			for i := range min(len(expected), len(actual)) {
+				assert.Equal(t, expected[i].Name, actual[i].Name)
+				assert.Equal(t, expected[i].AsString(), actual[i].AsString(), "query %s", expected[i].Name)
+			}
+		})
+	}
+}
@@ -1,8 +1,7 @@
 package usecases
 
 import (
-	"log/slog"
-	"os"
+	"fmt"
 
 	"github.com/hanapedia/metrics-processor/internal/application/usecases/query"
 	"github.com/hanapedia/metrics-processor/internal/application/usecases/query/container"
@@ -12,10 +11,13 @@ import (
 )
 
 func PrometheusQueryAdapter(config *domain.Config) *prometheus.PrometheusAdapter {
+	return exitOnAdapterError(newDefaultAdapter(config))
+}
+
+func newDefaultAdapter(config *domain.Config) (*prometheus.PrometheusAdapter, error) {
 	prometheusAdapter, err := prometheus.NewPrometheusAdapter(config)
 	if err != nil {
-		slog.Error("Failed to create new Prometheus adapter", "err", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to create new Prometheus adapter, %w", err)
 	}
 
 	rateDuration := config.Step * 16
@@ -64,5 +66,8 @@ func PrometheusQueryAdapter(config *domain.Config) *prometheus.PrometheusAdapter
 		prometheusAdapter.RegisterQuery(query)
 	}
 
-	return prometheusAdapter
+	if err := checkRateWindows(config, prometheusAdapter); err != nil {
+		return nil, err
+	}
+	return prometheusAdapter, nil
 }
@@ -1,8 +1,7 @@
 package usecases
 
 import (
-	"log/slog"
-	"os"
+	"fmt"
 	"slices"
 	"time"
 
@@ -15,10 +14,13 @@ import (
 )
 
 func HexagonPrometheusQueryAdapter(config *domain.Config) *prometheus.PrometheusAdapter {
+	return exitOnAdapterError(newHexagonAdapter(config))
+}
+
+func newHexagonAdapter(config *domain.Config) (*prometheus.PrometheusAdapter, error) {
 	prometheusAdapter, err := prometheus.NewPrometheusAdapter(config)
 	if err != nil {
-		slog.Error("Failed to create new Prometheus adapter", "err", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to create new Prometheus adapter, %w", err)
 	}
 
 	rateConfigs := []query.RateConfig{
@@ -209,5 +211,8 @@ func HexagonPrometheusQueryAdapter(config *domain.Config) *prometheus.Prometheus
 		}
 	}
 
-	return prometheusAdapter
+	if err := checkRateWindows(config, prometheusAdapter); err != nil {
+		return nil, err
+	}
+	return prometheusAdapter, nil
 }
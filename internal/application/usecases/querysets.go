@@ -0,0 +1,92 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/hanapedia/metrics-processor/internal/application/usecases/catalog"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus"
+	"github.com/hanapedia/metrics-processor/pkg/promql"
+)
+
+// QuerySets maps the name of each query set to its adapter constructor
+var QuerySets = map[string]func(*domain.Config) (*prometheus.PrometheusAdapter, error){
+	"hexagon": newHexagonAdapter,
+	"default": newDefaultAdapter,
+	"subset":  newSubsetAdapter,
+}
+
+// QuerySetNames lists the names of the query sets in QuerySets
+func QuerySetNames() []string {
+	var names []string
+	for name := range QuerySets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// QuerySetAdapter creates prometheusAdapter for a query set name, or for a catalog if no query set matches
+func QuerySetAdapter(config *domain.Config, name string) *prometheus.PrometheusAdapter {
+	return exitOnAdapterError(NewQuerySetAdapter(config, name))
+}
+
+// NewQuerySetAdapter is QuerySetAdapter returning errors instead of exiting
+func NewQuerySetAdapter(config *domain.Config, name string) (*prometheus.PrometheusAdapter, error) {
+	if constructor, ok := QuerySets[name]; ok {
+		return constructor(config)
+	}
+	queryCatalog, err := catalog.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open catalog %q, %w", name, err)
+	}
+	return newCatalogAdapter(config, queryCatalog)
+}
+
+// exitOnAdapterError exits when prometheusAdapter could not be created
+func exitOnAdapterError(prometheusAdapter *prometheus.PrometheusAdapter, err error) *prometheus.PrometheusAdapter {
+	if err != nil {
+		slog.Error("Failed to create query adapter", "err", err)
+		os.Exit(1)
+	}
+	return prometheusAdapter
+}
+
+// SelectQueries keeps the registered queries whose name matches any of the patterns.
+// Patterns are query names or globs such as *_rate_5m0s. Every pattern must match at least one query.
+// All queries are kept when no pattern is given.
+func SelectQueries(prometheusAdapter *prometheus.PrometheusAdapter, patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid query pattern %q, %w", pattern, err)
+		}
+	}
+
+	matched := make([]bool, len(patterns))
+	prometheusAdapter.Retain(func(query *promql.Query) bool {
+		keep := false
+		for i, pattern := range patterns {
+			if ok, _ := path.Match(pattern, query.Name); ok {
+				matched[i] = true
+				keep = true
+			}
+		}
+		return keep
+	})
+
+	var errs []error
+	for i, pattern := range patterns {
+		if !matched[i] {
+			errs = append(errs, fmt.Errorf("query pattern %q matches no query", pattern))
+		}
+	}
+	return errors.Join(errs...)
+}
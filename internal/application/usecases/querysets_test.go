@@ -0,0 +1,167 @@
+package usecases
+
+import (
+	"flag"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/application/core"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus/prometheustest"
+	"github.com/hanapedia/metrics-processor/pkg/promql"
+	"github.com/stretchr/testify/assert"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+func newSelectAdapter(t *testing.T, names ...string) *prometheus.PrometheusAdapter {
+	prometheusAdapter, err := prometheus.NewPrometheusAdapter(&domain.Config{MetricsQueryEndpoint: "http://localhost:9090"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		prometheusAdapter.RegisterQuery(promql.NewQuery("up").SetName(name))
+	}
+	return prometheusAdapter
+}
+
+func queryNames(prometheusAdapter *prometheus.PrometheusAdapter) []string {
+	var names []string
+	for _, query := range prometheusAdapter.Queries() {
+		names = append(names, query.Name)
+	}
+	return names
+}
+
+func TestSelectQueries(t *testing.T) {
+	all := []string{"memory_usage", "cpu_rate_5m0s", "cpu_rate_1m0s", "cpu_irate_1m0s"}
+	tests := []struct {
+		name     string
+		patterns []string
+		expected []string
+		err      string
+	}{
+		{name: "no pattern keeps every query", patterns: nil, expected: all},
+		{name: "names and globs", patterns: []string{"memory_usage", "*_rate_1m0s"}, expected: []string{"memory_usage", "cpu_rate_1m0s"}},
+		{name: "overlapping patterns", patterns: []string{"cpu_*", "*_1m0s"}, expected: []string{"cpu_rate_5m0s", "cpu_rate_1m0s", "cpu_irate_1m0s"}},
+		{name: "unmatched pattern", patterns: []string{"memory_usage", "disk_*"}, expected: []string{"memory_usage"}, err: `query pattern "disk_*" matches no query`},
+		{name: "invalid pattern", patterns: []string{"[memory"}, expected: all, err: `invalid query pattern "[memory"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prometheusAdapter := newSelectAdapter(t, all...)
+			err := SelectQueries(prometheusAdapter, tt.patterns)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+			assert.Equal(t, tt.expected, queryNames(prometheusAdapter))
+		})
+	}
+}
+
+func TestQuerySetsEndToEnd(t *testing.T) {
+	server := httptest.NewServer(prometheustest.NewAPI())
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		querySet  string
+		queryTask bool
+	}{
+		{name: "hexagon", querySet: "hexagon"},
+		{name: "hexagon with task metrics", querySet: "hexagon", queryTask: true},
+		{name: "default", querySet: "default"},
+		{name: "subset", querySet: "subset"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &domain.Config{
+				MetricsQueryEndpoint: server.URL,
+				StorageBackend:       domain.StorageBackendFilesystem,
+				StorageRoot:          t.TempDir(),
+				S3BucketDir:          "experiment/run-1",
+				EndTime:              time.Unix(1609459200, 0),
+				Duration:             30 * time.Minute,
+				Step:                 15 * time.Second,
+				K6TestName:           "test",
+				Namespace:            "emulation",
+				WorkloadContainers:   "server|redis",
+				QueryTaskMetrics:     tt.queryTask,
+				QueryParallelism:     4,
+			}
+			prometheusAdapter := QuerySetAdapter(config, tt.querySet)
+			storageAdapter := NewStorageAdapter(config)
+			run := domain.RunInfo{Command: tt.querySet, QuerySet: tt.querySet, Version: "test", Config: config}
+
+			report := core.NewMetricsProcessor(prometheusAdapter, storageAdapter, run).Process()
+			assert.Equal(t, prometheusAdapter.Len(), report.Total())
+			assert.Equal(t, 0, report.Failed())
+
+			manifest, err := storageAdapter.ReadManifest()
+			assert.NoError(t, err)
+			assert.Len(t, manifest.Queries, prometheusAdapter.Len())
+
+			names := queryNames(prometheusAdapter)
+			stored, err := storageAdapter.ReadStored(names)
+			assert.NoError(t, err)
+			assert.Len(t, stored, len(names))
+			for _, query := range stored {
+				assert.Positive(t, query.Samples, "query %s", query.Name)
+			}
+		})
+	}
+}
+
+// renderQueries renders the name and PromQL of every registered query, one per line
+func renderQueries(prometheusAdapter *prometheus.PrometheusAdapter) string {
+	var b strings.Builder
+	for _, query := range prometheusAdapter.Queries() {
+		fmt.Fprintf(&b, "%s: %s\n", query.Name, query.AsString())
+	}
+	return b.String()
+}
+
+func TestQuerySetsGolden(t *testing.T) {
+	tests := []struct {
+		name      string
+		adapter   func(*domain.Config) *prometheus.PrometheusAdapter
+		queryTask bool
+	}{
+		{name: "hexagon", adapter: HexagonPrometheusQueryAdapter},
+		{name: "hexagon-task-metrics", adapter: HexagonPrometheusQueryAdapter, queryTask: true},
+		{name: "default", adapter: PrometheusQueryAdapter},
+		{name: "subset", adapter: SubsetPrometheusQueryAdapter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &domain.Config{
+				MetricsQueryEndpoint: "http://localhost:9090",
+				EndTime:              time.Unix(1609459200, 0),
+				Duration:             30 * time.Minute,
+				Step:                 15 * time.Second,
+				K6TestName:           "test",
+				Namespace:            "emulation",
+				WorkloadContainers:   "server|redis",
+				QueryTaskMetrics:     tt.queryTask,
+			}
+			actual := renderQueries(tt.adapter(config))
+
+			golden := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				assert.NoError(t, os.MkdirAll("testdata", 0o755))
+				assert.NoError(t, os.WriteFile(golden, []byte(actual), 0o644))
+			}
+			expected, err := os.ReadFile(golden)
+			assert.NoError(t, err, "run go test with -update to create the golden file")
+			assert.Equal(t, string(expected), actual, "run go test with -update to accept the changes")
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package usecases
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus"
+)
+
+// checkRateWindows returns an error when a registered query has a rate window shorter than
+// domain.MinRateWindowScrapes scrape intervals. Lenient configs only warn.
+func checkRateWindows(config *domain.Config, prometheusAdapter *prometheus.PrometheusAdapter) error {
+	err := prometheusAdapter.ValidateRanges(config.MinRateWindow())
+	if err == nil {
+		return nil
+	}
+	if config.Lenient {
+		slog.Warn("Rate windows too short. Continuing in lenient mode", "err", err, "scrapeInterval", config.ScrapeInterval)
+		return nil
+	}
+	return fmt.Errorf("Rate windows too short for scrape interval %s, %w", config.ScrapeInterval, err)
+}
@@ -0,0 +1,56 @@
+package usecases
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/hanapedia/metrics-processor/internal/application/port"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/filesystem"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/s3"
+)
+
+// StorageAdapter is a storage backend that can store metrics, read them back and rewrite them
+type StorageAdapter interface {
+	port.MetricsStoragePort
+	port.MetricsSourcePort
+	port.MetricsMigrationPort
+}
+
+// NewStorageAdapter creates the storage adapter selected by config.StorageBackend
+func NewStorageAdapter(config *domain.Config) StorageAdapter {
+	switch config.StorageBackend {
+	case domain.StorageBackendFilesystem:
+		return NewFilesystemAdapter(config)
+	default:
+		return NewS3Adapter(config)
+	}
+}
+
+// OpenStorageAdapter is NewStorageAdapter returning errors instead of exiting
+func OpenStorageAdapter(config *domain.Config) (StorageAdapter, error) {
+	switch config.StorageBackend {
+	case domain.StorageBackendFilesystem:
+		adapter, err := filesystem.NewFilesystemAdapter(config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create new filesystem adapter, %w", err)
+		}
+		return adapter, nil
+	default:
+		adapter, err := s3.NewS3Adapter(config)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create new S3 adapter, %w", err)
+		}
+		return adapter, nil
+	}
+}
+
+func NewFilesystemAdapter(config *domain.Config) *filesystem.FilesystemAdapter {
+	adapter, err := filesystem.NewFilesystemAdapter(config)
+	if err != nil {
+		slog.Error("Failed to create new filesystem adapter", "err", err)
+		os.Exit(1)
+	}
+	return adapter
+}
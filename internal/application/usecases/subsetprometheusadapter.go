@@ -1,8 +1,7 @@
 package usecases
 
 import (
-	"log/slog"
-	"os"
+	"fmt"
 	"slices"
 	"time"
 
@@ -16,10 +15,13 @@ import (
 // SubsetPrometheusQueryAdapter creates proemtheusAdapter with subset of queries
 // use this adapter when partial requery is needed
 func SubsetPrometheusQueryAdapter(config *domain.Config) *prometheus.PrometheusAdapter {
+	return exitOnAdapterError(newSubsetAdapter(config))
+}
+
+func newSubsetAdapter(config *domain.Config) (*prometheus.PrometheusAdapter, error) {
 	prometheusAdapter, err := prometheus.NewPrometheusAdapter(config)
 	if err != nil {
-		slog.Error("Failed to create new Prometheus adapter", "err", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Failed to create new Prometheus adapter, %w", err)
 	}
 
 	rateConfigs := []query.RateConfig{
@@ -63,5 +65,8 @@ func SubsetPrometheusQueryAdapter(config *domain.Config) *prometheus.PrometheusA
 		}
 	}
 
-	return prometheusAdapter
+	if err := checkRateWindows(config, prometheusAdapter); err != nil {
+		return nil, err
+	}
+	return prometheusAdapter, nil
 }
@@ -1,17 +1,147 @@
 package domain
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 type Config struct {
 	MetricsQueryEndpoint string
 	EndTime              time.Time
 	Duration             time.Duration
 	Step                 time.Duration
+	StorageBackend       StorageBackend
+	StorageRoot          string
+	StorageBufferSize    int
+	StorageUploaders     int
+	StorageCompression   Compression
+	OutputFormat         OutputFormat
+	OutputSchema         OutputSchema
+	CSVLayout            CSVLayout
+	CSVNaN               string
+	CSVPosInf            string
+	CSVNegInf            string
 	AWSRegion            string
 	S3Bucket             string
 	S3BucketDir          string
+	S3Endpoint           string
+	S3ForcePathStyle     bool
+	S3InsecureSkipVerify bool
+	S3AccessKeyID        string
+	S3SecretAccessKey    string
+	S3SessionToken       string
+	S3Profile            string
 	K6TestName           string
 	Namespace            string
 	WorkloadContainers   string
 	QueryTaskMetrics     bool
+	FailurePolicy        FailurePolicy
+	QueryTimeout         time.Duration
+	QueryRetries         int
+	QueryBackoff         time.Duration
+	QueryMaxBackoff      time.Duration
+	QueryParallelism     int
+	QueryRateLimit       float64
+	QueryMaxPoints       int
+	QueryMaxSamples      int
+	ScrapeInterval       time.Duration
+	// Windows are queried one after another instead of the range given by EndTime and Duration
+	Windows []Window
+	// Window is the name of the window the config was derived for by ForWindow
+	Window string
+	// Lenient falls back to defaults on invalid settings and only warns about invalid configs
+	Lenient bool
+	// Force runs every query instead of resuming from the results already stored
+	Force bool
 }
+
+// MinRateWindowScrapes is the number of scrape intervals a rate window must span at least.
+// Shorter windows often contain fewer than two samples, leaving gaps in the result.
+const MinRateWindowScrapes = 4
+
+// MinRateWindow returns the shortest rate window accepted for the scrape interval
+func (c *Config) MinRateWindow() time.Duration {
+	return MinRateWindowScrapes * c.ScrapeInterval
+}
+
+// Validate checks that the config describes a sensible query range at now
+func (c *Config) Validate(now time.Time) error {
+	var errs []error
+	if c.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("duration must be positive, got %s", c.Duration))
+	}
+	if c.Step <= 0 {
+		errs = append(errs, fmt.Errorf("step must be positive, got %s", c.Step))
+	}
+	if c.Step > c.Duration {
+		errs = append(errs, fmt.Errorf("step %s is longer than duration %s", c.Step, c.Duration))
+	}
+	if c.EndTime.After(now) {
+		errs = append(errs, fmt.Errorf("end time %s is in the future", c.EndTime.Format(time.RFC3339)))
+	}
+	if c.Namespace == "" {
+		errs = append(errs, errors.New("namespace must not be empty"))
+	}
+	if c.K6TestName == "" {
+		errs = append(errs, errors.New("k6 test name must not be empty"))
+	}
+	if c.StorageBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("storage buffer size must not be negative, got %d", c.StorageBufferSize))
+	}
+	if c.StorageUploaders <= 0 {
+		errs = append(errs, fmt.Errorf("storage uploaders must be positive, got %d", c.StorageUploaders))
+	}
+	if c.ScrapeInterval <= 0 {
+		errs = append(errs, fmt.Errorf("scrape interval must be positive, got %s", c.ScrapeInterval))
+	}
+	if err := c.validateWindows(now); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+type StorageBackend string
+
+const (
+	StorageBackendS3         StorageBackend = "s3"
+	StorageBackendFilesystem StorageBackend = "filesystem"
+)
+
+// Compression of stored results
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// OutputFormat of stored results
+type OutputFormat string
+
+const (
+	OutputFormatJSON    OutputFormat = "json"
+	OutputFormatParquet OutputFormat = "parquet"
+	OutputFormatCSV     OutputFormat = "csv"
+)
+
+// OutputSchema of results stored as json
+type OutputSchema string
+
+const (
+	// OutputSchemaV1 is the legacy matrix keyed by the string of the series labels
+	OutputSchemaV1 OutputSchema = "v1"
+	// OutputSchemaV2 stores the query range and unit, and every series as an object with its labels and values
+	OutputSchemaV2 OutputSchema = "v2"
+)
+
+// CSVLayout of results stored as csv
+type CSVLayout string
+
+const (
+	// CSVLayoutLong writes one row per sample with a column per label
+	CSVLayoutLong CSVLayout = "long"
+	// CSVLayoutWide writes one row per timestamp with a column per series
+	CSVLayoutWide CSVLayout = "wide"
+)
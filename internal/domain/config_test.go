@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigValidate(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	valid := Config{
+		EndTime:          now.Add(-time.Minute),
+		Duration:         30 * time.Minute,
+		Step:             15 * time.Second,
+		Namespace:        "emulation",
+		K6TestName:       "test",
+		ScrapeInterval:   15 * time.Second,
+		StorageUploaders: 4,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		err    string
+	}{
+		{name: "valid", modify: func(c *Config) {}},
+		{name: "step longer than duration", modify: func(c *Config) { c.Step = time.Hour }, err: "step 1h0m0s is longer than duration 30m0s"},
+		{name: "non positive duration", modify: func(c *Config) { c.Duration = 0 }, err: "duration must be positive"},
+		{name: "end time in the future", modify: func(c *Config) { c.EndTime = now.Add(time.Hour) }, err: "end time 2021-01-01T01:00:00Z is in the future"},
+		{name: "empty namespace", modify: func(c *Config) { c.Namespace = "" }, err: "namespace must not be empty"},
+		{name: "empty test name", modify: func(c *Config) { c.K6TestName = "" }, err: "k6 test name must not be empty"},
+		{name: "negative storage buffer size", modify: func(c *Config) { c.StorageBufferSize = -1 }, err: "storage buffer size must not be negative"},
+		{name: "no storage uploaders", modify: func(c *Config) { c.StorageUploaders = 0 }, err: "storage uploaders must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+			err := config.Validate(now)
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	assert.Equal(t, time.Minute, valid.MinRateWindow())
+}
+
+func TestConfigWindows(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	config := Config{
+		EndTime:          now,
+		Duration:         time.Hour,
+		Step:             15 * time.Second,
+		Namespace:        "emulation",
+		K6TestName:       "test",
+		ScrapeInterval:   15 * time.Second,
+		StorageUploaders: 4,
+		S3BucketDir:      "experiment/run-1",
+		Windows: []Window{
+			{Name: "warmup", Start: now.Add(-time.Hour), End: now.Add(-45 * time.Minute)},
+			{Name: "steady", Start: now.Add(-45 * time.Minute), End: now},
+		},
+	}
+	assert.NoError(t, config.Validate(now))
+
+	configs := config.RunConfigs()
+	assert.Len(t, configs, 2)
+	assert.Equal(t, "experiment/run-1/warmup", configs[0].S3BucketDir)
+	assert.Equal(t, "warmup", configs[0].Window)
+	assert.Equal(t, 15*time.Minute, configs[0].Duration)
+	assert.True(t, now.Add(-time.Hour).Equal(configs[0].StartTime()))
+	assert.Equal(t, "experiment/run-1/steady", configs[1].S3BucketDir)
+	assert.Empty(t, configs[1].Windows)
+
+	config.Windows = []Window{
+		{Name: "a/b", Start: now.Add(-time.Hour), End: now},
+		{Name: "late", Start: now, End: now.Add(-time.Minute)},
+		{Name: "late", Start: now.Add(-time.Minute), End: now.Add(time.Minute)},
+	}
+	err := config.Validate(now)
+	assert.ErrorContains(t, err, `window name "a/b" must be non-empty`)
+	assert.ErrorContains(t, err, `window "late": start 2021-01-01T12:00:00Z is not before end`)
+	assert.ErrorContains(t, err, `window "late" is defined more than once`)
+	assert.ErrorContains(t, err, `window "late": end time 2021-01-01T12:01:00Z is in the future`)
+}
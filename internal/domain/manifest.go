@@ -0,0 +1,221 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"time"
+)
+
+// ManifestName is the name the manifest is stored under, next to the query results
+const ManifestName = "manifest"
+
+// RunInfo describes what produced a run
+type RunInfo struct {
+	Command  string
+	QuerySet string
+	Version  string
+	Config   *Config
+}
+
+// Manifest records how the results in a directory were produced
+type Manifest struct {
+	Command   string          `json:"command"`
+	QuerySet  string          `json:"query_set"`
+	Version   string          `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Window    string          `json:"window,omitempty"`
+	Start     time.Time       `json:"start"`
+	End       time.Time       `json:"end"`
+	Config    ManifestConfig  `json:"config"`
+	Queries   []ManifestQuery `json:"queries"`
+}
+
+// ManifestConfig is the part of the config that affects query results
+type ManifestConfig struct {
+	MetricsQueryEndpoint string `json:"metrics_query_endpoint"`
+	Duration             string `json:"duration"`
+	Step                 string `json:"step"`
+	Namespace            string `json:"namespace"`
+	K6TestName           string `json:"k6_test_name"`
+	WorkloadContainers   string `json:"workload_containers"`
+	QueryTaskMetrics     bool   `json:"query_task_metrics"`
+}
+
+// ManifestQuery records a single query of the run and the object its result was stored in
+type ManifestQuery struct {
+	Name     string `json:"name"`
+	Query    string `json:"query"`
+	Key      string `json:"key,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+	// Fingerprint identifies the query text and range of the stored result
+	Fingerprint string      `json:"fingerprint,omitempty"`
+	Series      int         `json:"series"`
+	Samples     int         `json:"samples"`
+	Bytes       int64       `json:"bytes"`
+	Status      QueryStatus `json:"status"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// NewManifest builds the manifest of a run from its report
+func NewManifest(run RunInfo, report *RunReport) *Manifest {
+	config := run.Config
+	manifest := &Manifest{
+		Command:   run.Command,
+		QuerySet:  run.QuerySet,
+		Version:   run.Version,
+		CreatedAt: time.Now().UTC(),
+		Window:    config.Window,
+		Start:     config.StartTime(),
+		End:       config.EndTime,
+		Config: ManifestConfig{
+			MetricsQueryEndpoint: config.MetricsQueryEndpoint,
+			Duration:             config.Duration.String(),
+			Step:                 config.Step.String(),
+			Namespace:            config.Namespace,
+			K6TestName:           config.K6TestName,
+			WorkloadContainers:   config.WorkloadContainers,
+			QueryTaskMetrics:     config.QueryTaskMetrics,
+		},
+	}
+	for _, result := range report.Results() {
+		manifest.Queries = append(manifest.Queries, ManifestQuery{
+			Name:        result.Name,
+			Query:       result.Query,
+			Key:         result.Key,
+			Checksum:    result.Checksum,
+			Fingerprint: result.Fingerprint,
+			Series:      result.Series,
+			Samples:     result.Samples,
+			Bytes:       result.Bytes,
+			Status:      result.Status,
+			Error:       result.Error,
+		})
+	}
+	return manifest
+}
+
+// Checksum returns the checksum recorded in the manifest for stored data
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// ChecksumWriter computes the checksum and size of the data written to it while it is streamed elsewhere
+type ChecksumWriter struct {
+	hash  hash.Hash
+	bytes int64
+}
+
+func NewChecksumWriter() *ChecksumWriter {
+	return &ChecksumWriter{hash: sha256.New()}
+}
+
+func (c *ChecksumWriter) Write(p []byte) (int, error) {
+	c.bytes += int64(len(p))
+	return c.hash.Write(p)
+}
+
+// Checksum returns the checksum of the data written so far, in the format of Checksum
+func (c *ChecksumWriter) Checksum() string {
+	return "sha256:" + hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// Bytes returns the number of bytes written so far
+func (c *ChecksumWriter) Bytes() int64 {
+	return c.bytes
+}
+
+// Fingerprint identifies a query by its text and query range.
+// A stored result can be reused when the fingerprint of the query to run matches the stored one.
+func Fingerprint(query string, start, end time.Time, step time.Duration) string {
+	return Checksum([]byte(fmt.Sprintf("%s\n%s\n%s\n%s", query, start.UTC().Format(time.RFC3339Nano), end.UTC().Format(time.RFC3339Nano), step)))
+}
+
+// StoredQuery describes a result stored under key as a manifest entry.
+// data is the stored object and matrix the result decoded from it.
+// It is used to resume runs that were interrupted before their manifest was written.
+func StoredQuery(key string, data []byte, matrix *MetricsMatrix) ManifestQuery {
+	stored := ManifestQuery{
+		Name:        matrix.Name,
+		Key:         key,
+		Checksum:    Checksum(data),
+		Fingerprint: matrix.Fingerprint,
+		Series:      len(matrix.Series),
+		Samples:     matrix.Samples(),
+		Bytes:       int64(len(data)),
+		Status:      QueryStatusOK,
+	}
+	return stored
+}
+
+// ApplyTo sets the query range and the workload settings recorded in the manifest on config
+func (m *Manifest) ApplyTo(config *Config) error {
+	step, err := time.ParseDuration(m.Config.Step)
+	if err != nil {
+		return fmt.Errorf("invalid step %q in manifest, %w", m.Config.Step, err)
+	}
+	config.EndTime = m.End
+	config.Duration = m.End.Sub(m.Start)
+	config.Step = step
+	config.Namespace = m.Config.Namespace
+	config.K6TestName = m.Config.K6TestName
+	config.WorkloadContainers = m.Config.WorkloadContainers
+	config.QueryTaskMetrics = m.Config.QueryTaskMetrics
+	config.Window = m.Window
+	config.Windows = nil
+	return nil
+}
+
+// Merge returns a copy of the manifest with the queries of update replacing queries of the same name.
+// Queries not in the manifest are appended. The rest of the manifest describes the original run and is kept.
+func (m *Manifest) Merge(update *Manifest) *Manifest {
+	merged := *m
+	merged.Version = update.Version
+	merged.Queries = append([]ManifestQuery{}, m.Queries...)
+
+	index := make(map[string]int, len(merged.Queries))
+	for i, query := range merged.Queries {
+		index[query.Name] = i
+	}
+	for _, query := range update.Queries {
+		if i, ok := index[query.Name]; ok {
+			merged.Queries[i] = query
+			continue
+		}
+		index[query.Name] = len(merged.Queries)
+		merged.Queries = append(merged.Queries, query)
+	}
+	return &merged
+}
+
+// Completed reports whether the manifest records the query as stored successfully with the same fingerprint.
+// Results stored without a fingerprint are never complete.
+func (m *Manifest) Completed(name, fingerprint string) bool {
+	query, ok := m.Query(name)
+	return ok && query.Status == QueryStatusOK && query.Fingerprint != "" && query.Fingerprint == fingerprint
+}
+
+// Query returns the entry of the named query
+func (m *Manifest) Query(name string) (ManifestQuery, bool) {
+	for _, query := range m.Queries {
+		if query.Name == name {
+			return query, true
+		}
+	}
+	return ManifestQuery{}, false
+}
+
+// Rewritten updates the checksum and size of the query stored under key after its object was rewritten with data.
+// It reports whether the manifest records a query stored under key.
+func (m *Manifest) Rewritten(key string, data []byte) bool {
+	for i := range m.Queries {
+		if m.Queries[i].Key == key {
+			m.Queries[i].Checksum = Checksum(data)
+			m.Queries[i].Bytes = int64(len(data))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestApplyTo(t *testing.T) {
+	end := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	manifest := &Manifest{
+		Window: "steady",
+		Start:  end.Add(-time.Hour),
+		End:    end,
+		Config: ManifestConfig{Step: "30s", Namespace: "emulation", K6TestName: "run-1", QueryTaskMetrics: true},
+	}
+	config := &Config{Duration: 30 * time.Minute, Step: 15 * time.Second, Windows: []Window{{Name: "other"}}}
+
+	assert.NoError(t, manifest.ApplyTo(config))
+	assert.True(t, end.Equal(config.EndTime))
+	assert.Equal(t, time.Hour, config.Duration)
+	assert.Equal(t, 30*time.Second, config.Step)
+	assert.Equal(t, "run-1", config.K6TestName)
+	assert.True(t, config.QueryTaskMetrics)
+	assert.Equal(t, "steady", config.Window)
+	assert.Empty(t, config.Windows)
+
+	manifest.Config.Step = "often"
+	assert.Error(t, manifest.ApplyTo(config))
+}
+
+func TestManifestMerge(t *testing.T) {
+	base := &Manifest{
+		Command:  "hexagon",
+		QuerySet: "hexagon",
+		Version:  "v1",
+		Queries: []ManifestQuery{
+			{Name: "a", Status: QueryStatusOK},
+			{Name: "b", Status: QueryStatusQueryFailed},
+		},
+	}
+	update := &Manifest{
+		Command:  "requery",
+		QuerySet: "subset",
+		Version:  "v2",
+		Queries: []ManifestQuery{
+			{Name: "b", Status: QueryStatusOK},
+			{Name: "c", Status: QueryStatusOK},
+		},
+	}
+
+	merged := base.Merge(update)
+	assert.Equal(t, "hexagon", merged.Command)
+	assert.Equal(t, "hexagon", merged.QuerySet)
+	assert.Equal(t, "v2", merged.Version)
+	assert.Equal(t, []ManifestQuery{
+		{Name: "a", Status: QueryStatusOK},
+		{Name: "b", Status: QueryStatusOK},
+		{Name: "c", Status: QueryStatusOK},
+	}, merged.Queries)
+	// the base manifest is not modified
+	assert.Equal(t, QueryStatusQueryFailed, base.Queries[1].Status)
+}
+
+func TestManifestCompleted(t *testing.T) {
+	manifest := &Manifest{
+		Queries: []ManifestQuery{
+			{Name: "a", Status: QueryStatusOK, Fingerprint: "sha256:a"},
+			{Name: "b", Status: QueryStatusSaveFailed, Fingerprint: "sha256:b"},
+			{Name: "legacy", Status: QueryStatusOK},
+		},
+	}
+	assert.True(t, manifest.Completed("a", "sha256:a"))
+	assert.False(t, manifest.Completed("a", "sha256:changed"))
+	assert.False(t, manifest.Completed("b", "sha256:b"))
+	assert.False(t, manifest.Completed("c", "sha256:c"))
+	assert.False(t, manifest.Completed("legacy", ""))
+}
+
+func TestFingerprint(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	fingerprint := Fingerprint("up", start, end, time.Minute)
+
+	assert.Equal(t, fingerprint, Fingerprint("up", start.In(time.FixedZone("JST", 9*60*60)), end, time.Minute))
+	assert.NotEqual(t, fingerprint, Fingerprint("up{job=\"a\"}", start, end, time.Minute))
+	assert.NotEqual(t, fingerprint, Fingerprint("up", start, end.Add(time.Minute), time.Minute))
+	assert.NotEqual(t, fingerprint, Fingerprint("up", start, end, time.Second))
+}
+
+func TestStoredQuery(t *testing.T) {
+	data := []byte("stored result")
+	matrix := &MetricsMatrix{
+		Name:        "a",
+		Fingerprint: "sha256:a",
+		Series: []Series{
+			{Labels: model.Metric{}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: 2}}},
+			{Labels: model.Metric{"pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}},
+		},
+	}
+	stored := StoredQuery("dir/a.json", data, matrix)
+	assert.Equal(t, ManifestQuery{
+		Name:        "a",
+		Key:         "dir/a.json",
+		Checksum:    Checksum(data),
+		Fingerprint: "sha256:a",
+		Series:      2,
+		Samples:     3,
+		Bytes:       int64(len(data)),
+		Status:      QueryStatusOK,
+	}, stored)
+}
+
+func TestManifestRewritten(t *testing.T) {
+	manifest := &Manifest{
+		Queries: []ManifestQuery{
+			{Name: "a", Key: "dir/a.json", Checksum: "sha256:old", Bytes: 3, Status: QueryStatusOK},
+			{Name: "b", Status: QueryStatusQueryFailed},
+		},
+	}
+	data := []byte("rewritten")
+	assert.True(t, manifest.Rewritten("dir/a.json", data))
+	assert.Equal(t, Checksum(data), manifest.Queries[0].Checksum)
+	assert.Equal(t, int64(len(data)), manifest.Queries[0].Bytes)
+	assert.False(t, manifest.Rewritten("dir/c.json", data))
+	assert.Empty(t, manifest.Queries[1].Checksum)
+}
@@ -1,13 +1,53 @@
 package domain
 
 import (
+	"time"
+
 	"github.com/prometheus/common/model"
 )
 
 type QueryName = string
 
+// ResultType is the type of the value a query returned
+type ResultType string
+
+const (
+	ResultTypeMatrix ResultType = "matrix"
+	ResultTypeVector ResultType = "vector"
+	ResultTypeScalar ResultType = "scalar"
+)
+
+// MetricsMatrix is the result of a query.
+// Results of instant queries are stored the same way. Their range starts and ends at the evaluation time,
+// and vectors and scalars have a single sample per series.
 type MetricsMatrix struct {
-	Name   string                        `json:"name"`
-	Matrix map[string][]model.SamplePair `json:"matrix"`
-	End    float64                       `json:"end"`
+	Name string
+	// Query is the PromQL text the result was queried with
+	Query string
+	// ResultType of the query, empty for results stored before it was recorded, which are matrices
+	ResultType ResultType
+	// Start, End and Step are the range the query was evaluated over. Step is 0 for instant queries.
+	Start time.Time
+	End   time.Time
+	Step  time.Duration
+	// Unit of the sample values such as seconds or bytes, empty when unknown
+	Unit string
+	// Fingerprint identifies the query text and range the matrix was queried with
+	Fingerprint string
+	Series      []Series
+}
+
+// Series is a single time series of a matrix
+type Series struct {
+	Labels model.Metric       `json:"labels"`
+	Values []model.SamplePair `json:"values"`
+}
+
+// Samples returns the number of samples in every series
+func (m *MetricsMatrix) Samples() int {
+	samples := 0
+	for _, series := range m.Series {
+		samples += len(series.Values)
+	}
+	return samples
 }
@@ -0,0 +1,216 @@
+package domain
+
+import (
+	"sync"
+	"time"
+)
+
+type QueryStatus string
+
+const (
+	QueryStatusPending     QueryStatus = "pending"
+	QueryStatusOK          QueryStatus = "ok"
+	QueryStatusQueryFailed QueryStatus = "query_failed"
+	QueryStatusSaveFailed  QueryStatus = "save_failed"
+)
+
+// QueryResult records the outcome of a single query
+type QueryResult struct {
+	Name     string        `json:"name"`
+	Query    string        `json:"query"`
+	Status   QueryStatus   `json:"status"`
+	Error    string        `json:"error,omitempty"`
+	Series   int           `json:"series"`
+	Samples  int           `json:"samples"`
+	Bytes    int64         `json:"bytes"`
+	Key      string        `json:"key,omitempty"`
+	Checksum string        `json:"checksum,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Attempts int           `json:"attempts"`
+	// Fingerprint identifies the query text and range the result was queried with
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Skipped is set when the result was already stored and the query was not run again
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// RunReport collects query results of a run.
+// It is safe for concurrent use by the query and storage adapters.
+type RunReport struct {
+	mu      sync.Mutex
+	results []*QueryResult
+	index   map[string]*QueryResult
+	// manifestErr is set when the manifest of the run could not be written
+	manifestErr error
+}
+
+func NewRunReport() *RunReport {
+	return &RunReport{
+		index: make(map[string]*QueryResult),
+	}
+}
+
+func (r *RunReport) result(name string) *QueryResult {
+	result, ok := r.index[name]
+	if !ok {
+		result = &QueryResult{Name: name, Status: QueryStatusPending}
+		r.index[name] = result
+		r.results = append(r.results, result)
+	}
+	return result
+}
+
+// RecordQuery records the outcome of running a query.
+// Query, Series, Samples, Duration, Attempts and Fingerprint are taken from queryResult. Status is derived from err.
+func (r *RunReport) RecordQuery(queryResult QueryResult, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.result(queryResult.Name)
+	result.Query = queryResult.Query
+	result.Series = queryResult.Series
+	result.Samples = queryResult.Samples
+	result.Duration = queryResult.Duration
+	result.Attempts = queryResult.Attempts
+	result.Fingerprint = queryResult.Fingerprint
+	if err != nil {
+		result.Status = QueryStatusQueryFailed
+		result.Error = err.Error()
+	}
+}
+
+// SavedObject describes where and how a query result was stored
+type SavedObject struct {
+	Key      string
+	Bytes    int64
+	Checksum string
+}
+
+// RecordSave records the outcome of storing a query result
+func (r *RunReport) RecordSave(name string, object SavedObject, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.result(name)
+	result.Key = object.Key
+	result.Bytes = object.Bytes
+	result.Checksum = object.Checksum
+	if err != nil {
+		result.Status = QueryStatusSaveFailed
+		result.Error = err.Error()
+		return
+	}
+	if result.Status == QueryStatusPending {
+		result.Status = QueryStatusOK
+	}
+}
+
+// RecordSkipped records a query that was not run because its result is already stored
+func (r *RunReport) RecordSkipped(stored ManifestQuery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := r.result(stored.Name)
+	result.Query = stored.Query
+	result.Series = stored.Series
+	result.Samples = stored.Samples
+	result.Bytes = stored.Bytes
+	result.Key = stored.Key
+	result.Checksum = stored.Checksum
+	result.Fingerprint = stored.Fingerprint
+	result.Status = QueryStatusOK
+	result.Skipped = true
+}
+
+// RecordManifest records the outcome of writing the manifest of the run
+func (r *RunReport) RecordManifest(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifestErr = err
+}
+
+// ManifestError returns the error of writing the manifest, or nil if it was written
+func (r *RunReport) ManifestError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.manifestErr
+}
+
+// Results returns a copy of the results in the order queries were first recorded
+func (r *RunReport) Results() []QueryResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]QueryResult, 0, len(r.results))
+	for _, result := range r.results {
+		results = append(results, *result)
+	}
+	return results
+}
+
+// Failed counts results that did not complete successfully
+func (r *RunReport) Failed() int {
+	failed := 0
+	for _, result := range r.Results() {
+		if result.Status != QueryStatusOK {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Skipped counts results that were already stored and not queried again
+func (r *RunReport) Skipped() int {
+	skipped := 0
+	for _, result := range r.Results() {
+		if result.Skipped {
+			skipped++
+		}
+	}
+	return skipped
+}
+
+// Total counts all results
+func (r *RunReport) Total() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.results)
+}
+
+type FailureMode string
+
+const (
+	// FailOnAny fails the run when any query fails
+	FailOnAny FailureMode = "any"
+	// FailAboveThreshold fails the run when the percentage of failed queries exceeds the threshold
+	FailAboveThreshold FailureMode = "threshold"
+	// FailNever never fails the run
+	FailNever FailureMode = "never"
+)
+
+// FailurePolicy decides whether a run with failed queries is considered failed
+type FailurePolicy struct {
+	Mode             FailureMode
+	ThresholdPercent float64
+}
+
+// ShouldFail reports whether the run failed according to policy.
+// A manifest that could not be written fails the run unless the policy never fails.
+func (r *RunReport) ShouldFail(policy FailurePolicy) bool {
+	failed := r.Failed()
+	switch {
+	case policy.Mode == FailNever:
+		return false
+	case r.ManifestError() != nil:
+		return true
+	}
+	switch policy.Mode {
+	case FailAboveThreshold:
+		total := r.Total()
+		if total == 0 {
+			return false
+		}
+		return float64(failed)/float64(total)*100 > policy.ThresholdPercent
+	default:
+		return failed > 0
+	}
+}
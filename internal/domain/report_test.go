@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunReportShouldFail(t *testing.T) {
+	report := NewRunReport()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		report.RecordQuery(QueryResult{Name: name, Query: "up", Series: 1, Samples: 10, Attempts: 1}, nil)
+	}
+	report.RecordSave("a", SavedObject{Key: "dir/a.json", Bytes: 100}, nil)
+	report.RecordSave("b", SavedObject{Key: "dir/b.json", Bytes: 100}, nil)
+	report.RecordSave("c", SavedObject{Key: "dir/c.json"}, errors.New("upload failed"))
+	// d was queried but never saved
+
+	assert.Equal(t, 4, report.Total())
+	assert.Equal(t, 2, report.Failed())
+	assert.Equal(t, QueryStatusSaveFailed, report.Results()[2].Status)
+	assert.Equal(t, QueryStatusPending, report.Results()[3].Status)
+
+	tests := []struct {
+		name     string
+		policy   FailurePolicy
+		expected bool
+	}{
+		{name: "any", policy: FailurePolicy{Mode: FailOnAny}, expected: true},
+		{name: "never", policy: FailurePolicy{Mode: FailNever}, expected: false},
+		{name: "below threshold", policy: FailurePolicy{Mode: FailAboveThreshold, ThresholdPercent: 50}, expected: false},
+		{name: "above threshold", policy: FailurePolicy{Mode: FailAboveThreshold, ThresholdPercent: 49}, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, report.ShouldFail(tt.policy))
+		})
+	}
+}
+
+func TestRunReportQueryFailure(t *testing.T) {
+	report := NewRunReport()
+	report.RecordQuery(QueryResult{Name: "a", Query: "up", Attempts: 3}, errors.New("timeout"))
+
+	results := report.Results()
+	assert.Equal(t, QueryStatusQueryFailed, results[0].Status)
+	assert.Equal(t, "timeout", results[0].Error)
+	assert.Equal(t, 3, results[0].Attempts)
+	assert.True(t, report.ShouldFail(FailurePolicy{Mode: FailOnAny}))
+}
@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// Window is a named query range such as warmup or steady-state
+type Window struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// StartTime returns the start of the query range
+func (c *Config) StartTime() time.Time {
+	return c.EndTime.Add(-c.Duration)
+}
+
+// ForWindow returns a copy of the config querying the window.
+// Results of the window are stored in a sub-directory named after it.
+func (c *Config) ForWindow(w Window) *Config {
+	config := *c
+	config.EndTime = w.End
+	config.Duration = w.End.Sub(w.Start)
+	config.S3BucketDir = path.Join(c.S3BucketDir, w.Name)
+	config.Window = w.Name
+	config.Windows = nil
+	return &config
+}
+
+// RunConfigs returns the config of every window, or the config itself when no window is set
+func (c *Config) RunConfigs() []*Config {
+	if len(c.Windows) == 0 {
+		return []*Config{c}
+	}
+	configs := make([]*Config, 0, len(c.Windows))
+	for _, w := range c.Windows {
+		configs = append(configs, c.ForWindow(w))
+	}
+	return configs
+}
+
+// validateWindows checks that every window is named uniquely and is a sensible query range at now
+func (c *Config) validateWindows(now time.Time) error {
+	var errs []error
+	seen := map[string]bool{}
+	for _, w := range c.Windows {
+		if w.Name == "" || strings.Contains(w.Name, "/") {
+			errs = append(errs, fmt.Errorf("window name %q must be non-empty and must not contain /", w.Name))
+		}
+		if seen[w.Name] {
+			errs = append(errs, fmt.Errorf("window %q is defined more than once", w.Name))
+		}
+		seen[w.Name] = true
+
+		if !w.Start.Before(w.End) {
+			errs = append(errs, fmt.Errorf("window %q: start %s is not before end %s", w.Name, w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339)))
+		} else if c.Step > w.End.Sub(w.Start) {
+			errs = append(errs, fmt.Errorf("window %q: step %s is longer than the window %s", w.Name, c.Step, w.End.Sub(w.Start)))
+		}
+		if w.End.After(now) {
+			errs = append(errs, fmt.Errorf("window %q: end time %s is in the future", w.Name, w.End.Format(time.RFC3339)))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+// Package compression compresses stored results and transparently decompresses them when read
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Extensions lists the extensions of stored results, uncompressed first
+var Extensions = []string{".json", ".json.gz", ".json.zst"}
+
+// Extension returns the extension of results stored with the compression
+func Extension(compression domain.Compression) string {
+	switch compression {
+	case domain.CompressionGzip:
+		return ".json.gz"
+	case domain.CompressionZstd:
+		return ".json.zst"
+	default:
+		return ".json"
+	}
+}
+
+// ContentEncoding returns the Content-Encoding of results stored with the compression, empty when uncompressed
+func ContentEncoding(compression domain.Compression) string {
+	switch compression {
+	case domain.CompressionGzip, domain.CompressionZstd:
+		return string(compression)
+	default:
+		return ""
+	}
+}
+
+// IsResult reports whether the file or object name is a stored result, compressed or not
+func IsResult(name string) bool {
+	for _, extension := range Extensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewWriter compresses the data written to it into w. Close flushes the compressed data but does not close w.
+func NewWriter(compression domain.Compression, w io.Writer) (io.WriteCloser, error) {
+	switch compression {
+	case domain.CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case domain.CompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopCloser{w}, nil
+	}
+}
+
+// Write compresses the content written by write into w
+func Write(compression domain.Compression, w io.Writer, write func(w io.Writer) error) error {
+	compressor, err := NewWriter(compression, w)
+	if err != nil {
+		return err
+	}
+	if err := write(compressor); err != nil {
+		compressor.Close()
+		return err
+	}
+	return compressor.Close()
+}
+
+// NewReader decompresses r based on its leading magic bytes.
+// Data that is not compressed, for example because the HTTP client already decompressed it, is returned as is.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(header, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return io.NopCloser(buffered), nil
+	}
+}
+
+// Decompress returns the decompressed content of data
+func Decompress(data []byte) ([]byte, error) {
+	reader, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress, %w", err)
+	}
+	defer reader.Close()
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress, %w", err)
+	}
+	return decompressed, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	content := []byte(`{"name":"a","matrix":{},"end":1}`)
+	tests := []struct {
+		compression domain.Compression
+		extension   string
+		encoding    string
+		magic       []byte
+	}{
+		{compression: domain.CompressionNone, extension: ".json", encoding: "", magic: []byte("{")},
+		{compression: domain.CompressionGzip, extension: ".json.gz", encoding: "gzip", magic: gzipMagic},
+		{compression: domain.CompressionZstd, extension: ".json.zst", encoding: "zstd", magic: zstdMagic},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.compression), func(t *testing.T) {
+			var buf bytes.Buffer
+			err := Write(tt.compression, &buf, func(w io.Writer) error {
+				_, err := w.Write(content)
+				return err
+			})
+			assert.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(buf.Bytes(), tt.magic))
+
+			decompressed, err := Decompress(buf.Bytes())
+			assert.NoError(t, err)
+			assert.Equal(t, content, decompressed)
+
+			assert.Equal(t, tt.extension, Extension(tt.compression))
+			assert.Equal(t, tt.encoding, ContentEncoding(tt.compression))
+			assert.True(t, IsResult("dir/a"+tt.extension))
+		})
+	}
+	assert.False(t, IsResult("dir/a.json.tmp"))
+}
+
+func TestDecompressInvalid(t *testing.T) {
+	_, err := Decompress(append(append([]byte{}, gzipMagic...), "not gzip"...))
+	assert.Error(t, err)
+
+	empty, err := Decompress(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+}
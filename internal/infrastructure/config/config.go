@@ -1,6 +1,8 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
 	"strconv"
 	"strings"
@@ -9,54 +11,319 @@ import (
 	"github.com/hanapedia/metrics-processor/internal/domain"
 )
 
-func NewConfigFromEnv() *domain.Config {
-	var endTime time.Time
-	if GetEnvs().END_TIME == "" {
-		endTime = time.Now()
-	} else {
-		endTime = parseStringUnixMilliSecTimestamp(GetEnvs().END_TIME)
+// parser collects invalid settings. Lenient parsers fall back to defaults and only warn.
+type parser struct {
+	lenient bool
+	errs    []error
+}
+
+func (p *parser) fallback(name string, err error, fallback string) {
+	if p.lenient {
+		slog.Warn(fmt.Sprintf("Failed to parse %s. Using %s", name, fallback), "err", err)
+		return
 	}
+	p.errs = append(p.errs, fmt.Errorf("invalid %s, %w", name, err))
+}
 
-	duration, err := time.ParseDuration(GetEnvs().DURATION)
+// newConfig parses the raw setting values.
+// Invalid settings are rejected unless LENIENT is set, in which case they fall back to defaults.
+func newConfig(envs *EnvVars) (*domain.Config, error) {
+	lenient, err := strconv.ParseBool(envs.LENIENT)
 	if err != nil {
-		slog.Warn("Failed to parse DURATION. Using 30m", "err", err)
+		return nil, fmt.Errorf("invalid LENIENT, %w", err)
+	}
+	p := &parser{lenient: lenient}
+
+	now := time.Now()
+	endTime := now
+	if envs.END_TIME != "" {
+		parsed, err := parseTime(envs.END_TIME, now)
+		if err != nil {
+			p.fallback("END_TIME", err, "time.Now()")
+		} else {
+			endTime = parsed
+		}
+	}
+
+	duration, err := time.ParseDuration(envs.DURATION)
+	if err != nil {
+		p.fallback("DURATION", err, "30m")
 		duration = 30 * time.Minute
 	}
 
-	step, err := time.ParseDuration(GetEnvs().STEP)
+	if envs.START_TIME != "" {
+		startTime, err := parseTime(envs.START_TIME, now)
+		if err != nil {
+			p.fallback("START_TIME", err, "DURATION")
+		} else {
+			duration = endTime.Sub(startTime)
+		}
+	}
+
+	windows, err := parseWindows(envs.WINDOWS, now)
 	if err != nil {
-		slog.Warn("Failed to parse STEP", "err", err)
+		p.fallback("WINDOWS", err, "no windows")
+		windows = nil
+	}
+
+	step, err := time.ParseDuration(envs.STEP)
+	if err != nil {
+		p.fallback("STEP", err, "15s")
 		step = 15 * time.Second
 	}
 
-	queryTask, err := strconv.ParseBool(GetEnvs().QUERY_TASK_METRICS)
+	queryTask, err := strconv.ParseBool(envs.QUERY_TASK_METRICS)
 	if err != nil {
-		slog.Warn("Failed to parse QUERY_TASK_METRICS", "err", err)
+		p.fallback("QUERY_TASK_METRICS", err, "false")
 		queryTask = false
 	}
 
-	return &domain.Config{
-		MetricsQueryEndpoint: GetEnvs().METRICS_QUERY_ENDPOINT,
+	queryTimeout, err := time.ParseDuration(envs.QUERY_TIMEOUT)
+	if err != nil {
+		p.fallback("QUERY_TIMEOUT", err, "5s")
+		queryTimeout = 5 * time.Second
+	}
+
+	queryRetries, err := strconv.Atoi(envs.QUERY_RETRIES)
+	if err != nil {
+		p.fallback("QUERY_RETRIES", err, "2")
+		queryRetries = 2
+	}
+
+	queryBackoff, err := time.ParseDuration(envs.QUERY_BACKOFF)
+	if err != nil {
+		p.fallback("QUERY_BACKOFF", err, "1s")
+		queryBackoff = time.Second
+	}
+
+	queryMaxBackoff, err := time.ParseDuration(envs.QUERY_MAX_BACKOFF)
+	if err != nil {
+		p.fallback("QUERY_MAX_BACKOFF", err, "30s")
+		queryMaxBackoff = 30 * time.Second
+	}
+
+	queryParallelism, err := strconv.Atoi(envs.QUERY_PARALLELISM)
+	if err != nil {
+		p.fallback("QUERY_PARALLELISM", err, "8")
+		queryParallelism = 8
+	}
+
+	queryRateLimit, err := strconv.ParseFloat(envs.QUERY_RATE_LIMIT, 64)
+	if err != nil {
+		p.fallback("QUERY_RATE_LIMIT", err, "0")
+		queryRateLimit = 0
+	}
+
+	queryMaxPoints, err := strconv.Atoi(envs.QUERY_MAX_POINTS)
+	if err != nil {
+		p.fallback("QUERY_MAX_POINTS", err, "11000")
+		queryMaxPoints = 11000
+	}
+
+	queryMaxSamples, err := strconv.Atoi(envs.QUERY_MAX_SAMPLES)
+	if err != nil {
+		p.fallback("QUERY_MAX_SAMPLES", err, "0")
+		queryMaxSamples = 0
+	}
+
+	s3ForcePathStyle, err := strconv.ParseBool(envs.S3_FORCE_PATH_STYLE)
+	if err != nil {
+		p.fallback("S3_FORCE_PATH_STYLE", err, "false")
+		s3ForcePathStyle = false
+	}
+
+	s3InsecureSkipVerify, err := strconv.ParseBool(envs.S3_INSECURE_SKIP_VERIFY)
+	if err != nil {
+		p.fallback("S3_INSECURE_SKIP_VERIFY", err, "false")
+		s3InsecureSkipVerify = false
+	}
+
+	scrapeInterval, err := time.ParseDuration(envs.SCRAPE_INTERVAL)
+	if err != nil {
+		p.fallback("SCRAPE_INTERVAL", err, "15s")
+		scrapeInterval = 15 * time.Second
+	}
+
+	storageBufferSize, err := strconv.Atoi(envs.STORAGE_BUFFER_SIZE)
+	if err != nil {
+		p.fallback("STORAGE_BUFFER_SIZE", err, "4")
+		storageBufferSize = 4
+	}
+
+	storageUploaders, err := strconv.Atoi(envs.STORAGE_UPLOADERS)
+	if err != nil {
+		p.fallback("STORAGE_UPLOADERS", err, "4")
+		storageUploaders = 4
+	}
+
+	force, err := strconv.ParseBool(envs.FORCE)
+	if err != nil {
+		p.fallback("FORCE", err, "false")
+		force = false
+	}
+
+	storageBackend := domain.StorageBackend(envs.STORAGE_BACKEND)
+	if storageBackend != domain.StorageBackendS3 && storageBackend != domain.StorageBackendFilesystem {
+		p.fallback("STORAGE_BACKEND", fmt.Errorf("unknown backend %q, must be s3 or filesystem", storageBackend), "s3")
+		storageBackend = domain.StorageBackendS3
+	}
+
+	storageCompression := domain.Compression(envs.STORAGE_COMPRESSION)
+	switch storageCompression {
+	case domain.CompressionNone, domain.CompressionGzip, domain.CompressionZstd:
+	default:
+		p.fallback("STORAGE_COMPRESSION", fmt.Errorf("unknown compression %q, must be none, gzip or zstd", storageCompression), "none")
+		storageCompression = domain.CompressionNone
+	}
+
+	outputFormat := domain.OutputFormat(envs.OUTPUT_FORMAT)
+	switch outputFormat {
+	case domain.OutputFormatJSON, domain.OutputFormatParquet, domain.OutputFormatCSV:
+	default:
+		p.fallback("OUTPUT_FORMAT", fmt.Errorf("unknown format %q, must be json, parquet or csv", outputFormat), "json")
+		outputFormat = domain.OutputFormatJSON
+	}
+
+	outputSchema := domain.OutputSchema(envs.OUTPUT_SCHEMA)
+	if outputSchema != domain.OutputSchemaV1 && outputSchema != domain.OutputSchemaV2 {
+		p.fallback("OUTPUT_SCHEMA", fmt.Errorf("unknown schema %q, must be v1 or v2", outputSchema), "v2")
+		outputSchema = domain.OutputSchemaV2
+	}
+
+	csvLayout := domain.CSVLayout(envs.CSV_LAYOUT)
+	if csvLayout != domain.CSVLayoutLong && csvLayout != domain.CSVLayoutWide {
+		p.fallback("CSV_LAYOUT", fmt.Errorf("unknown layout %q, must be long or wide", csvLayout), "long")
+		csvLayout = domain.CSVLayoutLong
+	}
+
+	failurePolicy := p.failurePolicy(envs.FAILURE_POLICY, envs.FAILURE_THRESHOLD)
+
+	if err := errors.Join(p.errs...); err != nil {
+		return nil, err
+	}
+
+	config := &domain.Config{
+		MetricsQueryEndpoint: envs.METRICS_QUERY_ENDPOINT,
 		EndTime:              endTime,
 		Duration:             duration,
 		Step:                 step,
-		AWSRegion:            GetEnvs().AWS_REGION,
-		S3Bucket:             GetEnvs().S3_BUCKET,
-		S3BucketDir:          GetEnvs().S3_BUCKET_DIR,
-		K6TestName:           GetEnvs().K6_TEST_NAME,
-		Namespace:            GetEnvs().NAMESPACE,
-		WorkloadContainers:   GetEnvs().WORKLOAD_CONTAINERS,
+		StorageBackend:       storageBackend,
+		StorageRoot:          envs.STORAGE_ROOT,
+		StorageBufferSize:    storageBufferSize,
+		StorageUploaders:     storageUploaders,
+		StorageCompression:   storageCompression,
+		OutputFormat:         outputFormat,
+		OutputSchema:         outputSchema,
+		CSVLayout:            csvLayout,
+		CSVNaN:               envs.CSV_NAN,
+		CSVPosInf:            envs.CSV_POS_INF,
+		CSVNegInf:            envs.CSV_NEG_INF,
+		AWSRegion:            envs.AWS_REGION,
+		S3Bucket:             envs.S3_BUCKET,
+		S3BucketDir:          envs.S3_BUCKET_DIR,
+		S3Endpoint:           envs.S3_ENDPOINT,
+		S3ForcePathStyle:     s3ForcePathStyle,
+		S3InsecureSkipVerify: s3InsecureSkipVerify,
+		S3AccessKeyID:        envs.S3_ACCESS_KEY_ID,
+		S3SecretAccessKey:    envs.S3_SECRET_ACCESS_KEY,
+		S3SessionToken:       envs.S3_SESSION_TOKEN,
+		S3Profile:            envs.S3_PROFILE,
+		K6TestName:           envs.K6_TEST_NAME,
+		Namespace:            envs.NAMESPACE,
+		WorkloadContainers:   envs.WORKLOAD_CONTAINERS,
 		QueryTaskMetrics:     queryTask,
+		FailurePolicy:        failurePolicy,
+		QueryTimeout:         queryTimeout,
+		QueryRetries:         queryRetries,
+		QueryBackoff:         queryBackoff,
+		QueryMaxBackoff:      queryMaxBackoff,
+		QueryParallelism:     queryParallelism,
+		QueryRateLimit:       queryRateLimit,
+		QueryMaxPoints:       queryMaxPoints,
+		QueryMaxSamples:      queryMaxSamples,
+		ScrapeInterval:       scrapeInterval,
+		Windows:              windows,
+		Lenient:              lenient,
+		Force:                force,
+	}
+	return config, nil
+}
+
+// failurePolicy parses the failure mode and the threshold percentage used by the threshold mode
+func (p *parser) failurePolicy(mode, threshold string) domain.FailurePolicy {
+	policy := domain.FailurePolicy{Mode: domain.FailureMode(mode)}
+	switch policy.Mode {
+	case domain.FailOnAny, domain.FailNever:
+	case domain.FailAboveThreshold:
+		percent, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			p.fallback("FAILURE_THRESHOLD", err, "0")
+			percent = 0
+		}
+		policy.ThresholdPercent = percent
+	default:
+		p.fallback("FAILURE_POLICY", fmt.Errorf("unknown policy %q, must be any, threshold or never", mode), "any")
+		policy.Mode = domain.FailOnAny
+	}
+	return policy
+}
+
+// parseTime parses a time in RFC3339, unix seconds or milliseconds, or relative to now such as now-45m
+func parseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if offset, ok := strings.CutPrefix(value, "now"); ok {
+		if offset == "" {
+			return now, nil
+		}
+		duration, err := time.ParseDuration(offset)
+		if err != nil || (offset[0] != '-' && offset[0] != '+') {
+			return time.Time{}, fmt.Errorf("invalid relative time %q, must be now, now-<duration> or now+<duration>", value)
+		}
+		return now.Add(duration), nil
+	}
+	if parsed, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return parsed, nil
+	}
+	parsed, err := parseStringUnixMilliSecTimestamp(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, must be RFC3339, a unix timestamp or relative to now like now-45m", value)
+	}
+	return parsed, nil
+}
+
+// parseWindows parses named windows given as name=start/end separated by commas
+func parseWindows(value string, now time.Time) ([]domain.Window, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var windows []domain.Window
+	for _, spec := range strings.Split(value, ",") {
+		name, timeRange, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok {
+			return nil, fmt.Errorf("window %q must be name=start/end", spec)
+		}
+		start, end, ok := strings.Cut(timeRange, "/")
+		if !ok {
+			return nil, fmt.Errorf("window %q must be name=start/end", spec)
+		}
+		startTime, err := parseTime(start, now)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: invalid start, %w", name, err)
+		}
+		endTime, err := parseTime(end, now)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: invalid end, %w", name, err)
+		}
+		windows = append(windows, domain.Window{Name: strings.TrimSpace(name), Start: startTime, End: endTime})
 	}
+	return windows, nil
 }
 
-func parseStringUnixMilliSecTimestamp(timestamp string) time.Time {
+func parseStringUnixMilliSecTimestamp(timestamp string) (time.Time, error) {
 	// Try to parse the input as a float for potential sub-second precision
 	unixTimeFloat, err := strconv.ParseFloat(timestamp, 64)
 	if err != nil {
-		// Log a warning and return the current time if parsing fails
-		slog.Warn("Failed to parse END_TIME. Using time.Now()", "err", err)
-		return time.Now()
+		return time.Time{}, err
 	}
 
 	// Check if the timestamp has sub-second precision (i.e., contains a dot)
@@ -64,14 +331,14 @@ func parseStringUnixMilliSecTimestamp(timestamp string) time.Time {
 		// Separate the integer seconds and the fractional milliseconds
 		seconds := int64(unixTimeFloat)
 		nanoSeconds := int64((unixTimeFloat - float64(seconds)) * 1e9)
-		return time.Unix(seconds, nanoSeconds)
+		return time.Unix(seconds, nanoSeconds), nil
 	}
 
 	// If it's an integer, check if it's in milliseconds (13 digits)
 	if len(timestamp) == 13 {
-		return time.UnixMilli(int64(unixTimeFloat))
+		return time.UnixMilli(int64(unixTimeFloat)), nil
 	}
 
 	// Fallback for a standard Unix timestamp in seconds
-	return time.Unix(int64(unixTimeFloat), 0)
+	return time.Unix(int64(unixTimeFloat), 0), nil
 }
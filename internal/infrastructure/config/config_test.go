@@ -34,18 +34,17 @@ func TestParseStringUnixMilliSecTimestamp(t *testing.T) {
 			expected:  time.Unix(1609459200, 123456789), // 123456789 nanoseconds
 		},
 		{
-			name:      "Invalid timestamp falls back to time.Now()",
+			name:      "Invalid timestamp is rejected",
 			timestamp: "invalid", // Invalid input
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual := parseStringUnixMilliSecTimestamp(tt.timestamp)
+			actual, err := parseStringUnixMilliSecTimestamp(tt.timestamp)
 
 			if tt.timestamp == "invalid" {
-				// We can't predict time.Now(), so just ensure that a valid time is returned
-				assert.NotZero(t, actual, "Expected time.Now(), but got zero time")
+				assert.Error(t, err)
 			} else {
 				// Use assert.InDelta to allow a small delta in the comparison to handle floating point precision issues
 				assert.InDelta(t, tt.expected.UnixNano(), actual.UnixNano(), 1000,
@@ -54,3 +53,50 @@ func TestParseStringUnixMilliSecTimestamp(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		value    string
+		expected time.Time
+		isValid  bool
+	}{
+		{name: "RFC3339", value: "2021-01-01T10:30:00Z", expected: time.Date(2021, 1, 1, 10, 30, 0, 0, time.UTC), isValid: true},
+		{name: "RFC3339 with offset", value: "2021-01-01T19:30:00+09:00", expected: time.Date(2021, 1, 1, 10, 30, 0, 0, time.UTC), isValid: true},
+		{name: "unix seconds", value: "1609459200", expected: time.Unix(1609459200, 0), isValid: true},
+		{name: "unix milliseconds", value: "1609459200123", expected: time.UnixMilli(1609459200123), isValid: true},
+		{name: "now", value: "now", expected: now, isValid: true},
+		{name: "relative past", value: "now-45m", expected: now.Add(-45 * time.Minute), isValid: true},
+		{name: "relative future", value: "now+1h", expected: now.Add(time.Hour), isValid: true},
+		{name: "relative without sign", value: "now5m"},
+		{name: "invalid", value: "yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseTime(tt.value, now)
+			if !tt.isValid {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tt.expected.Equal(actual), "Expected %v, but got %v", tt.expected, actual)
+		})
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	windows, err := parseWindows("warmup=now-60m/now-45m, steady=now-45m/2021-01-01T11:45:00Z", now)
+	assert.NoError(t, err)
+	assert.Len(t, windows, 2)
+	assert.Equal(t, "warmup", windows[0].Name)
+	assert.True(t, now.Add(-time.Hour).Equal(windows[0].Start))
+	assert.Equal(t, "steady", windows[1].Name)
+	assert.True(t, now.Add(-15*time.Minute).Equal(windows[1].End))
+
+	for _, value := range []string{"warmup", "warmup=now-60m", "warmup=now-60m/later"} {
+		_, err := parseWindows(value, now)
+		assert.Error(t, err, value)
+	}
+}
@@ -1,80 +1,145 @@
 package config
 
 import (
-	"os"
-	"strconv"
-	"sync"
+	"reflect"
+	"strings"
 )
 
+// EnvVars holds the raw value of every setting.
+// The field name is the environment variable. The flag and config file key are derived from it,
+// e.g. S3_BUCKET_DIR is set by --s3-bucket-dir and s3_bucket_dir.
 type EnvVars struct {
-	METRICS_QUERY_ENDPOINT string
-	END_TIME               string
-	DURATION               string
-	STEP                   string
-	AWS_REGION             string
-	S3_BUCKET              string
-	S3_BUCKET_DIR          string
-	K6_TEST_NAME           string
-	NAMESPACE              string
-	WORKLOAD_CONTAINERS    string
-	QUERY_TASK_METRICS     string
+	METRICS_QUERY_ENDPOINT  string `usage:"Prometheus HTTP API endpoint"`
+	END_TIME                string `usage:"End of the query range in RFC3339, unix seconds or milliseconds, or relative to now like now-5m. Defaults to now"`
+	START_TIME              string `usage:"Start of the query range in the same formats as END_TIME. Overrides DURATION"`
+	WINDOWS                 string `usage:"Named windows name=start/end separated by commas, queried instead of the range. Each window is stored in its own sub-directory"`
+	DURATION                string `usage:"Length of the query range"`
+	STEP                    string `usage:"Query resolution step"`
+	STORAGE_BACKEND         string `usage:"Storage backend, s3 or filesystem"`
+	STORAGE_ROOT            string `usage:"Root directory of the filesystem backend"`
+	STORAGE_BUFFER_SIZE     string `usage:"Number of query results held in memory waiting to be stored"`
+	STORAGE_UPLOADERS       string `usage:"Number of query results stored concurrently"`
+	STORAGE_COMPRESSION     string `usage:"Compression of stored results, none, gzip or zstd"`
+	OUTPUT_FORMAT           string `usage:"Format of stored results, json, parquet or csv"`
+	OUTPUT_SCHEMA           string `usage:"Schema of json results, v2 or the legacy v1 matrix keyed by series"`
+	CSV_LAYOUT              string `usage:"Layout of csv results, long (one row per sample) or wide (one column per series)"`
+	CSV_NAN                 string `usage:"Text written for NaN values in csv results"`
+	CSV_POS_INF             string `usage:"Text written for +Inf values in csv results"`
+	CSV_NEG_INF             string `usage:"Text written for -Inf values in csv results"`
+	AWS_REGION              string `usage:"AWS region of the bucket"`
+	S3_BUCKET               string `usage:"Bucket to store results in"`
+	S3_BUCKET_DIR           string `usage:"Directory results are stored under"`
+	S3_ENDPOINT             string `usage:"Endpoint of an S3-compatible store. Defaults to AWS S3"`
+	S3_FORCE_PATH_STYLE     string `usage:"Use path-style addressing"`
+	S3_INSECURE_SKIP_VERIFY string `usage:"Skip TLS certificate verification of the S3 endpoint"`
+	S3_ACCESS_KEY_ID        string `usage:"Explicit S3 access key id"`
+	S3_SECRET_ACCESS_KEY    string `usage:"Explicit S3 secret access key"`
+	S3_SESSION_TOKEN        string `usage:"Explicit S3 session token"`
+	S3_PROFILE              string `usage:"Profile in the shared AWS config files"`
+	K6_TEST_NAME            string `usage:"Name of the k6 test"`
+	NAMESPACE               string `usage:"Namespace of the workload"`
+	WORKLOAD_CONTAINERS     string `usage:"Regex matching the workload containers"`
+	QUERY_TASK_METRICS      string `usage:"Query per task metrics"`
+	FAILURE_POLICY          string `usage:"When a run with failed queries fails, any, threshold or never"`
+	FAILURE_THRESHOLD       string `usage:"Percentage of failed queries tolerated by the threshold policy"`
+	QUERY_TIMEOUT           string `usage:"Timeout of each query attempt"`
+	QUERY_RETRIES           string `usage:"Retries of a failed query"`
+	QUERY_BACKOFF           string `usage:"Initial backoff between retries"`
+	QUERY_MAX_BACKOFF       string `usage:"Maximum backoff between retries"`
+	QUERY_PARALLELISM       string `usage:"Number of queries run concurrently"`
+	QUERY_RATE_LIMIT        string `usage:"Maximum queries started per second. 0 disables the limit"`
+	QUERY_MAX_POINTS        string `usage:"Maximum points per series in one request"`
+	QUERY_MAX_SAMPLES       string `usage:"Maximum samples in one request. 0 disables the limit"`
+	SCRAPE_INTERVAL         string `usage:"Scrape interval of the metrics. Rate windows must span at least 4 intervals"`
+	LENIENT                 string `usage:"Fall back to defaults on invalid settings and only warn about invalid configs"`
+	FORCE                   string `usage:"Run every query, even when its result is already stored for the same query and range"`
 }
 
 var defaults = EnvVars{
-	METRICS_QUERY_ENDPOINT: "http://localhost:9090",
-	END_TIME:               "",
-	DURATION:               "30m",
-	STEP:                   "15s",
-	AWS_REGION:             "ap-northeast-1",
-	S3_BUCKET:              "test",
-	S3_BUCKET_DIR:          "test",
-	K6_TEST_NAME:           "test",
-	NAMESPACE:              "emulation",
-	WORKLOAD_CONTAINERS:    "server|redis",
-	QUERY_TASK_METRICS:     "false",
+	METRICS_QUERY_ENDPOINT:  "http://localhost:9090",
+	END_TIME:                "",
+	START_TIME:              "",
+	WINDOWS:                 "",
+	DURATION:                "30m",
+	STEP:                    "15s",
+	STORAGE_BACKEND:         "s3",
+	STORAGE_ROOT:            "data",
+	STORAGE_BUFFER_SIZE:     "4",
+	STORAGE_UPLOADERS:       "4",
+	STORAGE_COMPRESSION:     "none",
+	OUTPUT_FORMAT:           "json",
+	OUTPUT_SCHEMA:           "v2",
+	CSV_LAYOUT:              "long",
+	CSV_NAN:                 "NaN",
+	CSV_POS_INF:             "+Inf",
+	CSV_NEG_INF:             "-Inf",
+	AWS_REGION:              "ap-northeast-1",
+	S3_BUCKET:               "test",
+	S3_BUCKET_DIR:           "test",
+	S3_ENDPOINT:             "",
+	S3_FORCE_PATH_STYLE:     "false",
+	S3_INSECURE_SKIP_VERIFY: "false",
+	S3_ACCESS_KEY_ID:        "",
+	S3_SECRET_ACCESS_KEY:    "",
+	S3_SESSION_TOKEN:        "",
+	S3_PROFILE:              "",
+	K6_TEST_NAME:            "test",
+	NAMESPACE:               "emulation",
+	WORKLOAD_CONTAINERS:     "server|redis",
+	QUERY_TASK_METRICS:      "false",
+	FAILURE_POLICY:          "any",
+	FAILURE_THRESHOLD:       "0",
+	QUERY_TIMEOUT:           "5s",
+	QUERY_RETRIES:           "2",
+	QUERY_BACKOFF:           "1s",
+	QUERY_MAX_BACKOFF:       "30s",
+	QUERY_PARALLELISM:       "8",
+	QUERY_RATE_LIMIT:        "0",
+	QUERY_MAX_POINTS:        "11000",
+	QUERY_MAX_SAMPLES:       "0",
+	SCRAPE_INTERVAL:         "15s",
+	LENIENT:                 "false",
+	FORCE:                   "false",
 }
 
-var envVars *EnvVars
-var once sync.Once
+// setting describes one field of EnvVars
+type setting struct {
+	env   string
+	usage string
+	index int
+}
 
-func GetEnvs() *EnvVars {
-	once.Do(func() {
-		envVars = loadEnvVariables()
-	})
-	return envVars
+// flag returns the command line flag of the setting, e.g. s3-bucket-dir
+func (s setting) flag() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
 }
 
-func loadEnvVariables() *EnvVars {
-	return &EnvVars{
-		METRICS_QUERY_ENDPOINT: readEnv("METRICS_QUERY_ENDPOINT", defaults.METRICS_QUERY_ENDPOINT),
-		END_TIME:               readEnv("END_TIME", defaults.END_TIME),
-		DURATION:               readEnv("DURATION", defaults.DURATION),
-		STEP:                   readEnv("STEP", defaults.STEP),
-		AWS_REGION:             readEnv("AWS_REGION", defaults.AWS_REGION),
-		S3_BUCKET:              readEnv("S3_BUCKET", defaults.S3_BUCKET),
-		S3_BUCKET_DIR:          readEnv("S3_BUCKET_DIR", defaults.S3_BUCKET_DIR),
-		K6_TEST_NAME:           readEnv("K6_TEST_NAME", defaults.K6_TEST_NAME),
-		NAMESPACE:              readEnv("NAMESPACE", defaults.NAMESPACE),
-		WORKLOAD_CONTAINERS:    readEnv("WORKLOAD_CONTAINERS", defaults.WORKLOAD_CONTAINERS),
-		QUERY_TASK_METRICS:     readEnv("QUERY_TASK_METRICS", defaults.QUERY_TASK_METRICS),
-	}
+// key returns the config file key of the setting, e.g. s3_bucket_dir
+func (s setting) key() string {
+	return strings.ToLower(s.env)
 }
 
-func readEnv(key, defaultValue string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
-	}
-	return defaultValue
+// isBool reports whether the setting is a boolean, so that its flag can be given without a value
+func (s setting) isBool() bool {
+	value := s.get(&defaults)
+	return value == "true" || value == "false"
+}
+
+func (s setting) get(envs *EnvVars) string {
+	return reflect.ValueOf(envs).Elem().Field(s.index).String()
+}
+
+func (s setting) set(envs *EnvVars, value string) {
+	reflect.ValueOf(envs).Elem().Field(s.index).SetString(value)
 }
 
-func readBoolEnv(key string, defaultValue bool) bool {
-	boolValue := defaultValue
-	if value, ok := os.LookupEnv(key); ok {
-		parsed, err := strconv.ParseBool(value)
-		if err != nil {
-			return boolValue
-		}
-		return parsed
+// settings lists every setting in the order of EnvVars
+func settings() []setting {
+	t := reflect.TypeOf(EnvVars{})
+	settings := make([]setting, 0, t.NumField())
+	for i := range t.NumField() {
+		field := t.Field(i)
+		settings = append(settings, setting{env: field.Name, usage: field.Tag.Get("usage"), index: i})
 	}
-	return boolValue
+	return settings
 }
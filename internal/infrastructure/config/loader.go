@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileFlag is the flag of the config file
+const ConfigFileFlag = "config"
+
+// RegisterFlags adds a flag for every setting and the config file flag to flags
+func RegisterFlags(flags *pflag.FlagSet) {
+	flags.String(ConfigFileFlag, "", "YAML or TOML config file. Precedence is flag > env > file > default")
+	for _, s := range settings() {
+		flags.String(s.flag(), s.get(&defaults), fmt.Sprintf("%s (env %s)", s.usage, s.env))
+		if s.isBool() {
+			flags.Lookup(s.flag()).NoOptDefVal = "true"
+		}
+	}
+}
+
+// Loader resolves the config from flags, environment variables, a config file and defaults.
+// Precedence is flag > env > file > default.
+type Loader struct {
+	flags     *pflag.FlagSet
+	lookupEnv func(string) (string, bool)
+}
+
+// NewLoader creates a loader reading the flags registered with RegisterFlags. flags may be nil.
+func NewLoader(flags *pflag.FlagSet) *Loader {
+	return &Loader{
+		flags:     flags,
+		lookupEnv: os.LookupEnv,
+	}
+}
+
+// Load resolves and validates the config.
+// Invalid configs are rejected unless LENIENT is set, in which case problems are only logged.
+func (l *Loader) Load() (*domain.Config, error) {
+	envs, err := l.Envs()
+	if err != nil {
+		return nil, err
+	}
+	config, err := newConfig(envs)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config, %w", err)
+	}
+	if err := config.Validate(time.Now()); err != nil {
+		if !config.Lenient {
+			return nil, fmt.Errorf("Invalid config, %w", err)
+		}
+		slog.Warn("Invalid config. Continuing in lenient mode", "err", err)
+	}
+	return config, nil
+}
+
+// Envs resolves the raw value of every setting
+func (l *Loader) Envs() (*EnvVars, error) {
+	file, err := l.readFile()
+	if err != nil {
+		return nil, err
+	}
+
+	envs := defaults
+	for _, s := range settings() {
+		if value, ok := file[s.key()]; ok {
+			s.set(&envs, value)
+		}
+		if value, ok := l.lookupEnv(s.env); ok {
+			s.set(&envs, value)
+		}
+		if flag := l.lookupFlag(s.flag()); flag != nil && flag.Changed {
+			s.set(&envs, flag.Value.String())
+		}
+	}
+	return &envs, nil
+}
+
+func (l *Loader) lookupFlag(name string) *pflag.Flag {
+	if l.flags == nil {
+		return nil
+	}
+	return l.flags.Lookup(name)
+}
+
+// readFile reads the config file given by the config file flag, if any
+func (l *Loader) readFile() (map[string]string, error) {
+	flag := l.lookupFlag(ConfigFileFlag)
+	if flag == nil || flag.Value.String() == "" {
+		return nil, nil
+	}
+	path := flag.Value.String()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read config file %q, %w", path, err)
+	}
+
+	raw := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("Unsupported config file extension %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse config file %q, %w", path, err)
+	}
+
+	return fileValues(raw)
+}
+
+// fileValues converts the scalar values of a config file to raw setting values
+func fileValues(raw map[string]any) (map[string]string, error) {
+	known := map[string]bool{}
+	for _, s := range settings() {
+		known[s.key()] = true
+	}
+
+	values := make(map[string]string, len(raw))
+	var unknown []string
+	for key, value := range raw {
+		if !known[key] {
+			unknown = append(unknown, key)
+			continue
+		}
+		switch v := value.(type) {
+		case nil:
+			values[key] = ""
+		case string, bool, int, int64, uint64, float64:
+			values[key] = fmt.Sprint(v)
+		case time.Time:
+			values[key] = v.Format(time.RFC3339Nano)
+		default:
+			return nil, fmt.Errorf("Config file key %q must be a scalar, got %T", key, value)
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return nil, fmt.Errorf("Unknown config file keys %s", strings.Join(unknown, ", "))
+	}
+	return values, nil
+}
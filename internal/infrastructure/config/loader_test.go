@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLoader(t *testing.T, args []string, env map[string]string) *Loader {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	loader := NewLoader(flags)
+	loader.lookupEnv = func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	return loader
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoaderPrecedence(t *testing.T) {
+	file := writeConfigFile(t, "config.yaml", `
+duration: 1h
+step: 30s
+namespace: from-file
+k6_test_name: from-file
+query_task_metrics: true
+query_parallelism: 4
+`)
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		expected string
+	}{
+		{name: "default", args: nil, env: nil, expected: "emulation"},
+		{name: "file over default", args: []string{"--config", file}, env: nil, expected: "from-file"},
+		{name: "env over file", args: []string{"--config", file}, env: map[string]string{"NAMESPACE": "from-env"}, expected: "from-env"},
+		{name: "flag over env", args: []string{"--config", file, "--namespace", "from-flag"}, env: map[string]string{"NAMESPACE": "from-env"}, expected: "from-flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := newTestLoader(t, tt.args, tt.env).Load()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, config.Namespace)
+		})
+	}
+
+	config, err := newTestLoader(t, []string{"--config", file, "--step", "1m"}, nil).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, config.Duration)
+	assert.Equal(t, time.Minute, config.Step)
+	assert.True(t, config.QueryTaskMetrics)
+	assert.Equal(t, 4, config.QueryParallelism)
+}
+
+func TestLoaderTOML(t *testing.T) {
+	file := writeConfigFile(t, "config.toml", `
+duration = "2h"
+query_rate_limit = 2.5
+s3_force_path_style = true
+`)
+
+	config, err := newTestLoader(t, []string{"--config", file}, nil).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, config.Duration)
+	assert.Equal(t, 2.5, config.QueryRateLimit)
+	assert.True(t, config.S3ForcePathStyle)
+}
+
+func TestLoaderBoolFlagWithoutValue(t *testing.T) {
+	config, err := newTestLoader(t, []string{"--query-task-metrics"}, nil).Load()
+	assert.NoError(t, err)
+	assert.True(t, config.QueryTaskMetrics)
+}
+
+func TestLoaderInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{name: "unknown key", file: "config.yaml", content: "namspace: typo\n"},
+		{name: "nested value", file: "config.yaml", content: "namespace:\n  a: b\n"},
+		{name: "unsupported extension", file: "config.json", content: "{}"},
+		{name: "invalid yaml", file: "config.yaml", content: "namespace: [\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfigFile(t, tt.file, tt.content)
+			_, err := newTestLoader(t, []string{"--config", path}, nil).Load()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoaderStrict(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{name: "unparsable duration", args: []string{"--duration", "30"}, err: "invalid DURATION"},
+		{name: "unparsable bool", args: []string{"--query-task-metrics=yes"}, err: "invalid QUERY_TASK_METRICS"},
+		{name: "unparsable end time", args: []string{"--end-time", "yesterday"}, err: "invalid END_TIME"},
+		{name: "unknown storage backend", args: []string{"--storage-backend", "gcs"}, err: "invalid STORAGE_BACKEND"},
+		{name: "unknown compression", args: []string{"--storage-compression", "lz4"}, err: "invalid STORAGE_COMPRESSION"},
+		{name: "unknown output schema", args: []string{"--output-schema", "v3"}, err: "invalid OUTPUT_SCHEMA"},
+		{name: "unknown csv layout", args: []string{"--csv-layout", "tall"}, err: "invalid CSV_LAYOUT"},
+		{name: "step longer than duration", args: []string{"--step", "1h"}, err: "step 1h0m0s is longer than duration 30m0s"},
+		{name: "empty namespace", args: []string{"--namespace", ""}, err: "namespace must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestLoader(t, tt.args, nil).Load()
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoaderLenient(t *testing.T) {
+	config, err := newTestLoader(t, []string{"--lenient", "--duration", "30", "--step", "abc", "--namespace", ""}, nil).Load()
+	assert.NoError(t, err)
+	assert.True(t, config.Lenient)
+	assert.Equal(t, 30*time.Minute, config.Duration)
+	assert.Equal(t, 15*time.Second, config.Step)
+	assert.Equal(t, "", config.Namespace)
+}
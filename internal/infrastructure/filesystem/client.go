@@ -0,0 +1,232 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/format"
+)
+
+// FilesystemAdapter stores metrics as files under a root directory
+// using the same <dir>/<name>.<extension> layout as the S3 adapter.
+type FilesystemAdapter struct {
+	root         string
+	keyParentDir string
+	encoder      *format.Encoder
+}
+
+func NewFilesystemAdapter(config *domain.Config) (*FilesystemAdapter, error) {
+	if config.StorageRoot == "" {
+		return nil, fmt.Errorf("Storage root must not be empty")
+	}
+	return &FilesystemAdapter{
+		root:         config.StorageRoot,
+		keyParentDir: config.S3BucketDir,
+		encoder:      format.NewEncoder(config),
+	}, nil
+}
+
+func (fa *FilesystemAdapter) Save(metricsChan <-chan *domain.MetricsMatrix, report *domain.RunReport) {
+	for metricsMatrix := range metricsChan {
+		key := getResultKey(fa.keyParentDir, metricsMatrix.Name, fa.encoder.Extension())
+		path := filepath.Join(fa.root, key)
+		// Stream the encoded result into the file
+		checksum := domain.NewChecksumWriter()
+		err := writeFile(path, func(w io.Writer) error {
+			return fa.encoder.Encode(io.MultiWriter(w, checksum), metricsMatrix)
+		})
+		if err != nil {
+			slog.Error("Failed to write file", "err", err, "path", path)
+			report.RecordSave(metricsMatrix.Name, domain.SavedObject{Key: key}, err)
+			continue
+		}
+		report.RecordSave(metricsMatrix.Name, domain.SavedObject{
+			Key:      key,
+			Bytes:    checksum.Bytes(),
+			Checksum: checksum.Checksum(),
+		}, nil)
+	}
+}
+
+func (fa *FilesystemAdapter) WriteManifest(manifest *domain.Manifest) error {
+	jsonData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode manifest, %w", err)
+	}
+	path := filepath.Join(fa.root, getKey(fa.keyParentDir, domain.ManifestName))
+	err = writeFile(path, func(w io.Writer) error {
+		_, err := w.Write(jsonData)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to write manifest %q, %w", path, err)
+	}
+	slog.Info("Manifest saved", "path", path)
+	return nil
+}
+
+func (fa *FilesystemAdapter) ReadManifest() (*domain.Manifest, error) {
+	path := filepath.Join(fa.root, getKey(fa.keyParentDir, domain.ManifestName))
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read manifest %q, %w", path, err)
+	}
+	var manifest domain.Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal manifest, %w", err)
+	}
+	return &manifest, nil
+}
+
+func (fa *FilesystemAdapter) ReadStored(names []string) ([]domain.ManifestQuery, error) {
+	var stored []domain.ManifestQuery
+	for _, name := range names {
+		for _, extension := range fa.encoder.Extensions() {
+			key := getResultKey(fa.keyParentDir, name, extension)
+			body, err := os.ReadFile(filepath.Join(fa.root, key))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read file content, %w", err)
+			}
+			metricsMatrix, err := format.Decode(key, body)
+			if err != nil {
+				return nil, err
+			}
+			stored = append(stored, domain.StoredQuery(key, body, metricsMatrix))
+			break
+		}
+	}
+	return stored, nil
+}
+
+func (fa *FilesystemAdapter) ParseEndTime() (time.Time, error) {
+	// Use the first result in lexical order other than the manifest, like the S3 listing
+	keys, err := fa.ListResults()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(keys) == 0 {
+		return time.Time{}, fmt.Errorf("No files found in %s", filepath.Join(fa.root, fa.keyParentDir))
+	}
+	path := filepath.Join(fa.root, keys[0])
+	slog.Info("Found file", "file", path)
+
+	body, err := fa.ReadResult(keys[0])
+	if err != nil {
+		return time.Time{}, err
+	}
+	data, err := format.Decode(path, body)
+	if err != nil {
+		return time.Time{}, err
+	}
+	slog.Info("Successfuly parsed endtime", "root", fa.root, "parentDir", fa.keyParentDir, "file", path, "end", data.End)
+	return data.End, nil
+}
+
+func (fa *FilesystemAdapter) ListResults() ([]string, error) {
+	dir := filepath.Join(fa.root, fa.keyParentDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list files in %q, %w", dir, err)
+	}
+	manifestName := fmt.Sprintf("%s.json", domain.ManifestName)
+	var keys []string
+	for _, entry := range entries {
+		if !entry.IsDir() && format.IsResult(entry.Name()) && entry.Name() != manifestName {
+			keys = append(keys, filepath.Join(fa.keyParentDir, entry.Name()))
+		}
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (fa *FilesystemAdapter) ReadResult(key string) ([]byte, error) {
+	body, err := os.ReadFile(filepath.Join(fa.root, key))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file content, %w", err)
+	}
+	return body, nil
+}
+
+func (fa *FilesystemAdapter) WriteResult(key string, data []byte) error {
+	path := filepath.Join(fa.root, key)
+	err := writeFile(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Unable to write file %q, %w", path, err)
+	}
+	return nil
+}
+
+func (fa *FilesystemAdapter) ListRuns() ([]string, error) {
+	dir := filepath.Join(fa.root, fa.keyParentDir)
+	seen := map[string]bool{}
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !format.IsResult(entry.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(fa.root, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !seen[rel] {
+			seen[rel] = true
+			dirs = append(dirs, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list files in %q, %w", dir, err)
+	}
+	slices.Sort(dirs)
+	return dirs, nil
+}
+
+// writeFile streams the content written by write to a temporary file and renames it so that readers never see partial files
+func writeFile(path string, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// getKey returns the path of a json file relative to the root, matching the S3 key
+func getKey(prefix, name string) string {
+	return getResultKey(prefix, name, ".json")
+}
+
+// getResultKey returns the path of a result stored with the extension of its format
+func getResultKey(prefix, name, extension string) string {
+	return filepath.Join(prefix, name+extension)
+}
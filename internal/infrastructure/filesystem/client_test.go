@@ -0,0 +1,214 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAndParseEndTime(t *testing.T) {
+	root := t.TempDir()
+	adapter, err := NewFilesystemAdapter(&domain.Config{StorageRoot: root, S3BucketDir: "experiment/run-1"})
+	assert.NoError(t, err)
+
+	metricsChan := make(chan *domain.MetricsMatrix, 2)
+	for _, name := range []string{"b_query", "a_query"} {
+		metricsChan <- &domain.MetricsMatrix{
+			Name:   name,
+			Series: []domain.Series{{Labels: model.Metric{"pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}}},
+			End:    time.UnixMilli(1609459200500),
+		}
+	}
+	close(metricsChan)
+
+	report := domain.NewRunReport()
+	adapter.Save(metricsChan, report)
+	assert.Equal(t, 0, report.Failed())
+	assert.FileExists(t, filepath.Join(root, "experiment/run-1/a_query.json"))
+	assert.FileExists(t, filepath.Join(root, "experiment/run-1/b_query.json"))
+
+	end, err := adapter.ParseEndTime()
+	assert.NoError(t, err)
+	assert.True(t, time.UnixMilli(1609459200500).Equal(end))
+}
+
+func TestManifest(t *testing.T) {
+	root := t.TempDir()
+	config := &domain.Config{
+		StorageRoot: root,
+		S3BucketDir: "experiment/run-1",
+		EndTime:     time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		Duration:    time.Hour,
+		Step:        time.Minute,
+	}
+	adapter, err := NewFilesystemAdapter(config)
+	assert.NoError(t, err)
+
+	metricsChan := make(chan *domain.MetricsMatrix, 1)
+	metricsChan <- &domain.MetricsMatrix{Name: "z_query", End: time.Unix(1609459200, 0)}
+	close(metricsChan)
+	report := domain.NewRunReport()
+	report.RecordQuery(domain.QueryResult{Name: "z_query", Query: "up", Series: 1}, nil)
+	adapter.Save(metricsChan, report)
+
+	run := domain.RunInfo{Command: "default", QuerySet: "default", Version: "test", Config: config}
+	assert.NoError(t, adapter.WriteManifest(domain.NewManifest(run, report)))
+	assert.FileExists(t, filepath.Join(root, "experiment/run-1/manifest.json"))
+
+	manifest, err := adapter.ReadManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, "default", manifest.QuerySet)
+	assert.True(t, config.EndTime.Equal(manifest.End))
+	assert.Equal(t, "1m0s", manifest.Config.Step)
+	assert.Len(t, manifest.Queries, 1)
+	assert.Equal(t, "experiment/run-1/z_query.json", manifest.Queries[0].Key)
+	assert.Equal(t, domain.QueryStatusOK, manifest.Queries[0].Status)
+	assert.Contains(t, manifest.Queries[0].Checksum, "sha256:")
+
+	// the manifest sorts before z_query.json but must not be used as a result
+	end, err := adapter.ParseEndTime()
+	assert.NoError(t, err)
+	assert.True(t, time.Unix(1609459200, 0).Equal(end))
+}
+
+func TestParseEndTimeEmptyDir(t *testing.T) {
+	root := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "empty"), 0o755))
+	adapter, err := NewFilesystemAdapter(&domain.Config{StorageRoot: root, S3BucketDir: "empty"})
+	assert.NoError(t, err)
+
+	_, err = adapter.ParseEndTime()
+	assert.Error(t, err)
+}
+
+func TestListRuns(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"experiment/run-1/a_query.json",
+		"experiment/run-1/manifest.json",
+		"experiment/run-2/warmup/a_query.json",
+		"experiment/run-2/steady/a_query.json",
+		"experiment/run-3/notes.txt",
+		"experiment-requery/run-1/a_query.json",
+	} {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte("{}"), 0o644))
+	}
+	adapter, err := NewFilesystemAdapter(&domain.Config{StorageRoot: root, S3BucketDir: "experiment"})
+	assert.NoError(t, err)
+
+	runs, err := adapter.ListRuns()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"experiment/run-1", "experiment/run-2/steady", "experiment/run-2/warmup"}, runs)
+}
+
+func TestReadStored(t *testing.T) {
+	root := t.TempDir()
+	adapter, err := NewFilesystemAdapter(&domain.Config{StorageRoot: root, S3BucketDir: "experiment/run-1"})
+	assert.NoError(t, err)
+
+	metricsChan := make(chan *domain.MetricsMatrix, 1)
+	metricsChan <- &domain.MetricsMatrix{
+		Name:        "a_query",
+		Series:      []domain.Series{{Labels: model.Metric{"pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}}},
+		Fingerprint: "sha256:a",
+	}
+	close(metricsChan)
+	report := domain.NewRunReport()
+	adapter.Save(metricsChan, report)
+
+	stored, err := adapter.ReadStored([]string{"a_query", "b_query"})
+	assert.NoError(t, err)
+	assert.Len(t, stored, 1)
+	assert.Equal(t, "experiment/run-1/a_query.json", stored[0].Key)
+	assert.Equal(t, "sha256:a", stored[0].Fingerprint)
+	assert.Equal(t, report.Results()[0].Checksum, stored[0].Checksum)
+	assert.Equal(t, 1, stored[0].Samples)
+}
+
+func TestEncodedResults(t *testing.T) {
+	tests := []struct {
+		format      domain.OutputFormat
+		compression domain.Compression
+		file        string
+	}{
+		{compression: domain.CompressionGzip, file: "a_query.json.gz"},
+		{compression: domain.CompressionZstd, file: "a_query.json.zst"},
+		{format: domain.OutputFormatParquet, file: "a_query.parquet"},
+		{format: domain.OutputFormatParquet, compression: domain.CompressionZstd, file: "a_query.parquet"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file+"/"+string(tt.compression), func(t *testing.T) {
+			root := t.TempDir()
+			config := &domain.Config{StorageRoot: root, S3BucketDir: "experiment/run-1", OutputFormat: tt.format, StorageCompression: tt.compression}
+			adapter, err := NewFilesystemAdapter(config)
+			assert.NoError(t, err)
+
+			metricsChan := make(chan *domain.MetricsMatrix, 1)
+			metricsChan <- &domain.MetricsMatrix{
+				Name:        "a_query",
+				Series:      []domain.Series{{Labels: model.Metric{"pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}}},
+				End:         time.Unix(1609459200, 0),
+				Fingerprint: "sha256:a",
+			}
+			close(metricsChan)
+			report := domain.NewRunReport()
+			adapter.Save(metricsChan, report)
+			assert.Equal(t, 0, report.Failed())
+			assert.FileExists(t, filepath.Join(root, "experiment/run-1", tt.file))
+			assert.Equal(t, "experiment/run-1/"+tt.file, report.Results()[0].Key)
+
+			end, err := adapter.ParseEndTime()
+			assert.NoError(t, err)
+			assert.True(t, time.Unix(1609459200, 0).Equal(end))
+
+			// results stay readable after the format and compression settings changed
+			uncompressed, err := NewFilesystemAdapter(&domain.Config{StorageRoot: root, S3BucketDir: "experiment/run-1"})
+			assert.NoError(t, err)
+			stored, err := uncompressed.ReadStored([]string{"a_query"})
+			assert.NoError(t, err)
+			assert.Len(t, stored, 1)
+			assert.Equal(t, "sha256:a", stored[0].Fingerprint)
+			assert.Equal(t, report.Results()[0].Checksum, stored[0].Checksum)
+
+			runs, err := (&FilesystemAdapter{root: root, keyParentDir: "experiment"}).ListRuns()
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"experiment/run-1"}, runs)
+		})
+	}
+}
+
+func TestMigrationResults(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"experiment/run-1/b_query.json.gz",
+		"experiment/run-1/a_query.json",
+		"experiment/run-1/manifest.json",
+		"experiment/run-1/notes.txt",
+		"experiment/run-1/warmup/a_query.json",
+	} {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte("{}"), 0o644))
+	}
+	adapter, err := NewFilesystemAdapter(&domain.Config{StorageRoot: root, S3BucketDir: "experiment/run-1"})
+	assert.NoError(t, err)
+
+	keys, err := adapter.ListResults()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"experiment/run-1/a_query.json", "experiment/run-1/b_query.json.gz"}, keys)
+
+	assert.NoError(t, adapter.WriteResult(keys[0], []byte(`{"schema_version":2}`)))
+	data, err := adapter.ReadResult(keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, `{"schema_version":2}`, string(data))
+
+	_, err = adapter.ReadResult("experiment/run-1/c_query.json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package format
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/prometheus/common/model"
+)
+
+// csvOptions selects the layout of csv results and the text of non-finite values
+type csvOptions struct {
+	layout domain.CSVLayout
+	nan    string
+	posInf string
+	negInf string
+}
+
+// formatValue formats a sample value, writing the configured text for NaN and infinities
+func (o csvOptions) formatValue(value model.SampleValue) string {
+	v := float64(value)
+	switch {
+	case math.IsNaN(v):
+		return o.nan
+	case math.IsInf(v, 1):
+		return o.posInf
+	case math.IsInf(v, -1):
+		return o.negInf
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// encodeCSV writes the matrix as csv in the configured layout
+func encodeCSV(w io.Writer, metricsMatrix *domain.MetricsMatrix, options csvOptions) error {
+	writer := csv.NewWriter(w)
+	var err error
+	if options.layout == domain.CSVLayoutWide {
+		err = writeWideCSV(writer, metricsMatrix, options)
+	} else {
+		err = writeLongCSV(writer, metricsMatrix, options)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write csv, %w", err)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("Failed to write csv, %w", err)
+	}
+	return nil
+}
+
+// writeLongCSV writes one row per sample with the columns timestamp, query_name, one column per label and value.
+// Labels clashing with a fixed column are prefixed with label_ like in parquet results.
+func writeLongCSV(writer *csv.Writer, metricsMatrix *domain.MetricsMatrix, options csvOptions) error {
+	columns := labelColumns(metricsMatrix.Series)
+	labelNames := make([]string, 0, len(columns))
+	for column := range columns {
+		labelNames = append(labelNames, column)
+	}
+	slices.Sort(labelNames)
+
+	header := append(append([]string{timestampColumn, queryNameColumn}, labelNames...), valueColumn)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, series := range metricsMatrix.Series {
+		record[1] = metricsMatrix.Name
+		for i, column := range labelNames {
+			record[2+i] = string(series.Labels[model.LabelName(columns[column])])
+		}
+		for _, sample := range series.Values {
+			record[0] = formatTimestamp(sample.Timestamp)
+			record[len(record)-1] = options.formatValue(sample.Value)
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeWideCSV writes one row per timestamp with a timestamp column and one column per series, named by its labels.
+// Series without a sample at a timestamp leave the cell empty.
+func writeWideCSV(writer *csv.Writer, metricsMatrix *domain.MetricsMatrix, options csvOptions) error {
+	header := []string{timestampColumn}
+	values := make(map[model.Time][]string)
+	for i, series := range metricsMatrix.Series {
+		header = append(header, series.Labels.String())
+		for _, sample := range series.Values {
+			row, ok := values[sample.Timestamp]
+			if !ok {
+				row = make([]string, len(metricsMatrix.Series))
+				values[sample.Timestamp] = row
+			}
+			row[i] = options.formatValue(sample.Value)
+		}
+	}
+	timestamps := make([]model.Time, 0, len(values))
+	for timestamp := range values {
+		timestamps = append(timestamps, timestamp)
+	}
+	slices.Sort(timestamps)
+
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, timestamp := range timestamps {
+		if err := writer.Write(append([]string{formatTimestamp(timestamp)}, values[timestamp]...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatTimestamp(timestamp model.Time) string {
+	return time.UnixMilli(int64(timestamp)).UTC().Format(timeLayout)
+}
@@ -0,0 +1,175 @@
+// Package format encodes query results in the configured output format and decodes stored results of any format
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/compression"
+)
+
+const parquetExtension = ".parquet"
+
+// timeLayout formats times as RFC 3339 in UTC with millisecond precision
+const timeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// Encoder encodes query results in an output format
+type Encoder struct {
+	format      domain.OutputFormat
+	compression domain.Compression
+	schema      domain.OutputSchema
+	csv         csvOptions
+}
+
+func NewEncoder(config *domain.Config) *Encoder {
+	return &Encoder{
+		format:      config.OutputFormat,
+		compression: config.StorageCompression,
+		schema:      config.OutputSchema,
+		csv: csvOptions{
+			layout: config.CSVLayout,
+			nan:    config.CSVNaN,
+			posInf: config.CSVPosInf,
+			negInf: config.CSVNegInf,
+		},
+	}
+}
+
+// Extension returns the extension of encoded results
+func (e *Encoder) Extension() string {
+	switch e.format {
+	case domain.OutputFormatParquet:
+		return parquetExtension
+	case domain.OutputFormatCSV:
+		return ".csv" + strings.TrimPrefix(compression.Extension(e.compression), ".json")
+	}
+	return compression.Extension(e.compression)
+}
+
+// Extensions lists the extensions of stored results that can be decoded, starting with the extension of encoded results.
+// csv results are an export format and are not read back.
+func (e *Encoder) Extensions() []string {
+	var extensions []string
+	if IsResult(e.Extension()) {
+		extensions = append(extensions, e.Extension())
+	}
+	for _, extension := range append(compression.Extensions, parquetExtension) {
+		if !slices.Contains(extensions, extension) {
+			extensions = append(extensions, extension)
+		}
+	}
+	return extensions
+}
+
+// ContentType returns the media type of encoded results
+func (e *Encoder) ContentType() string {
+	switch e.format {
+	case domain.OutputFormatParquet:
+		return "application/vnd.apache.parquet"
+	case domain.OutputFormatCSV:
+		return "text/csv"
+	}
+	return "application/json"
+}
+
+// ContentEncoding returns the Content-Encoding of encoded results, empty when the content is not compressed as a whole.
+// Parquet compresses its pages instead.
+func (e *Encoder) ContentEncoding() string {
+	if e.format == domain.OutputFormatParquet {
+		return ""
+	}
+	return compression.ContentEncoding(e.compression)
+}
+
+// Encode writes the matrix to w
+func (e *Encoder) Encode(w io.Writer, metricsMatrix *domain.MetricsMatrix) error {
+	switch e.format {
+	case domain.OutputFormatParquet:
+		return encodeParquet(w, metricsMatrix, e.compression)
+	case domain.OutputFormatCSV:
+		return compression.Write(e.compression, w, func(w io.Writer) error {
+			return encodeCSV(w, metricsMatrix, e.csv)
+		})
+	}
+	encode := encodeJSON
+	if e.schema == domain.OutputSchemaV1 {
+		encode = encodeLegacyJSON
+	}
+	return compression.Write(e.compression, w, func(w io.Writer) error {
+		return encode(w, metricsMatrix)
+	})
+}
+
+// IsResult reports whether the file or object name is a stored result that can be decoded
+func IsResult(name string) bool {
+	return compression.IsResult(name) || strings.HasSuffix(name, parquetExtension)
+}
+
+// Decode reads a result stored under key in any format
+func Decode(key string, data []byte) (*domain.MetricsMatrix, error) {
+	if strings.HasSuffix(key, parquetExtension) {
+		return decodeParquet(key, data)
+	}
+	content, err := compression.Decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(key, content)
+}
+
+// EncoderFor returns an encoder writing results in the format and compression of the result stored under key,
+// in the current json schema
+func EncoderFor(key string) *Encoder {
+	encoder := &Encoder{format: domain.OutputFormatJSON, schema: domain.OutputSchemaV2}
+	switch {
+	case strings.HasSuffix(key, parquetExtension):
+		encoder.format = domain.OutputFormatParquet
+	case strings.HasSuffix(key, compression.Extension(domain.CompressionGzip)):
+		encoder.compression = domain.CompressionGzip
+	case strings.HasSuffix(key, compression.Extension(domain.CompressionZstd)):
+		encoder.compression = domain.CompressionZstd
+	}
+	return encoder
+}
+
+// Migrate rewrites a json result stored in the v1 schema in the current schema, keeping its compression.
+// The end time of results stored with the legacy scaling is corrected and rescaled reports it.
+// The query, start and step missing from v1 results are taken from the manifest of the run when given.
+// migrated is nil when the result is not a v1 json result.
+func Migrate(key string, data []byte, manifest *domain.Manifest) (migrated []byte, rescaled bool, err error) {
+	if !compression.IsResult(key) {
+		return nil, false, nil
+	}
+	content, err := compression.Decompress(data)
+	if err != nil {
+		return nil, false, err
+	}
+	version, err := jsonSchemaVersion(key, content)
+	if err != nil || version != legacySchemaVersion {
+		return nil, false, err
+	}
+	metricsMatrix, rescaled, err := decodeLegacyJSON(key, content)
+	if err != nil {
+		return nil, false, err
+	}
+	if manifest != nil {
+		if query, ok := manifest.Query(metricsMatrix.Name); ok {
+			metricsMatrix.Query = query.Query
+		}
+		metricsMatrix.Start = manifest.Start
+		if step, err := time.ParseDuration(manifest.Config.Step); err == nil {
+			metricsMatrix.Step = step
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncoderFor(key).Encode(&buf, metricsMatrix); err != nil {
+		return nil, false, fmt.Errorf("Failed to encode %q, %w", key, err)
+	}
+	return buf.Bytes(), rescaled, nil
+}
@@ -0,0 +1,221 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/compression"
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMatrix() *domain.MetricsMatrix {
+	values := []model.SamplePair{{Timestamp: 1609459200000, Value: 1}, {Timestamp: 1609459215000, Value: model.SampleValue(math.Inf(1))}}
+	return &domain.MetricsMatrix{
+		Name:        "a_query",
+		Query:       `sum by (pod) (rate(up[1m]))`,
+		ResultType:  domain.ResultTypeMatrix,
+		Start:       time.UnixMilli(1609459200000).UTC(),
+		End:         time.UnixMilli(1609459215500).UTC(),
+		Step:        15 * time.Second,
+		Unit:        "seconds",
+		Fingerprint: "sha256:a",
+		Series: []domain.Series{
+			{Labels: model.Metric{}, Values: values},
+			{Labels: model.Metric{"pod": "a", "value": "x"}, Values: values},
+			{Labels: model.Metric{"__name__": "up", "label_value": "y", "pod": "b"}, Values: values},
+		},
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		format      domain.OutputFormat
+		schema      domain.OutputSchema
+		compression domain.Compression
+		extension   string
+		key         string
+	}{
+		{format: domain.OutputFormatJSON, schema: domain.OutputSchemaV2, extension: ".json", key: "dir/a_query.json"},
+		{format: domain.OutputFormatJSON, schema: domain.OutputSchemaV1, extension: ".json", key: "dir/a_query.json"},
+		{format: domain.OutputFormatJSON, schema: domain.OutputSchemaV2, compression: domain.CompressionGzip, extension: ".json.gz", key: "dir/a_query.json.gz"},
+		{format: domain.OutputFormatParquet, extension: ".parquet", key: "dir/a_query.parquet"},
+		{format: domain.OutputFormatParquet, compression: domain.CompressionGzip, extension: ".parquet", key: "dir/a_query.parquet"},
+		{format: domain.OutputFormatParquet, compression: domain.CompressionZstd, extension: ".parquet", key: "dir/a_query.parquet"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format)+"/"+string(tt.schema)+"/"+string(tt.compression), func(t *testing.T) {
+			encoder := NewEncoder(&domain.Config{OutputFormat: tt.format, OutputSchema: tt.schema, StorageCompression: tt.compression})
+			assert.Equal(t, tt.extension, encoder.Extension())
+			assert.Equal(t, tt.extension, encoder.Extensions()[0])
+			assert.ElementsMatch(t, []string{".json", ".json.gz", ".json.zst", ".parquet"}, encoder.Extensions())
+			assert.True(t, IsResult(tt.key))
+
+			expected := newMatrix()
+			var buf bytes.Buffer
+			assert.NoError(t, encoder.Encode(&buf, expected))
+
+			decoded, err := Decode(tt.key, buf.Bytes())
+			assert.NoError(t, err)
+			assert.Equal(t, expected.Name, decoded.Name)
+			assert.True(t, expected.End.Equal(decoded.End))
+			assert.Equal(t, expected.Fingerprint, decoded.Fingerprint)
+			assert.ElementsMatch(t, expected.Series, decoded.Series)
+			if tt.schema != domain.OutputSchemaV1 {
+				assert.Equal(t, expected.Query, decoded.Query)
+				assert.Equal(t, expected.ResultType, decoded.ResultType)
+				assert.True(t, expected.Start.Equal(decoded.Start))
+				assert.Equal(t, expected.Step, decoded.Step)
+				assert.Equal(t, expected.Unit, decoded.Unit)
+			}
+		})
+	}
+	assert.False(t, IsResult("dir/a_query.parquet.tmp"))
+}
+
+func TestParquetColumns(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := NewEncoder(&domain.Config{OutputFormat: domain.OutputFormatParquet})
+	assert.NoError(t, encoder.Encode(&buf, newMatrix()))
+	assert.Empty(t, encoder.ContentEncoding())
+
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), file.NumRows())
+	// labels clashing with a fixed column or another label column are prefixed
+	assert.Equal(t, [][]string{
+		{"__name__"}, {"label_label_value"}, {"label_value"}, {"pod"}, {"query_name"}, {"timestamp"}, {"value"},
+	}, file.Schema().Columns())
+
+	labels, ok := file.Lookup(labelsMetadata)
+	assert.True(t, ok)
+	var columns map[string]string
+	assert.NoError(t, json.Unmarshal([]byte(labels), &columns))
+	assert.Equal(t, map[string]string{
+		"__name__":          "__name__",
+		"label_label_value": "value",
+		"label_value":       "label_value",
+		"pod":               "pod",
+	}, columns)
+}
+
+func TestCSV(t *testing.T) {
+	metricsMatrix := &domain.MetricsMatrix{
+		Name: "a_query",
+		Series: []domain.Series{
+			{Labels: model.Metric{"pod": "a"}, Values: []model.SamplePair{{Timestamp: 0, Value: 1.5}, {Timestamp: 15000, Value: model.SampleValue(math.NaN())}}},
+			{Labels: model.Metric{"pod": "b", "value": "x"}, Values: []model.SamplePair{{Timestamp: 15000, Value: model.SampleValue(math.Inf(-1))}, {Timestamp: 30000, Value: model.SampleValue(math.Inf(1))}}},
+		},
+	}
+	tests := []struct {
+		name     string
+		config   domain.Config
+		expected string
+	}{
+		{
+			name:   "long",
+			config: domain.Config{CSVLayout: domain.CSVLayoutLong, CSVNaN: "NaN", CSVPosInf: "+Inf", CSVNegInf: "-Inf"},
+			expected: "timestamp,query_name,label_value,pod,value\n" +
+				"1970-01-01T00:00:00.000Z,a_query,,a,1.5\n" +
+				"1970-01-01T00:00:15.000Z,a_query,,a,NaN\n" +
+				"1970-01-01T00:00:15.000Z,a_query,x,b,-Inf\n" +
+				"1970-01-01T00:00:30.000Z,a_query,x,b,+Inf\n",
+		},
+		{
+			name:   "wide",
+			config: domain.Config{CSVLayout: domain.CSVLayoutWide, CSVNaN: "NA", CSVPosInf: "Inf", CSVNegInf: ""},
+			expected: `timestamp,"{pod=""a""}","{pod=""b"", value=""x""}"` + "\n" +
+				"1970-01-01T00:00:00.000Z,1.5,\n" +
+				"1970-01-01T00:00:15.000Z,NA,\n" +
+				"1970-01-01T00:00:30.000Z,,Inf\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.OutputFormat = domain.OutputFormatCSV
+			encoder := NewEncoder(&tt.config)
+			assert.Equal(t, ".csv", encoder.Extension())
+			assert.Equal(t, "text/csv", encoder.ContentType())
+			// csv results are not read back
+			assert.NotContains(t, encoder.Extensions(), ".csv")
+			assert.False(t, IsResult("dir/a_query.csv"))
+
+			var buf bytes.Buffer
+			assert.NoError(t, encoder.Encode(&buf, metricsMatrix))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+
+	encoder := NewEncoder(&domain.Config{OutputFormat: domain.OutputFormatCSV, StorageCompression: domain.CompressionGzip})
+	assert.Equal(t, ".csv.gz", encoder.Extension())
+	assert.Equal(t, "gzip", encoder.ContentEncoding())
+	var buf bytes.Buffer
+	assert.NoError(t, encoder.Encode(&buf, metricsMatrix))
+	assert.Equal(t, []byte{0x1f, 0x8b}, buf.Bytes()[:2])
+}
+
+func TestMigrate(t *testing.T) {
+	manifest := &domain.Manifest{
+		Start:   time.UnixMilli(1609455600000).UTC(),
+		Config:  domain.ManifestConfig{Step: "15s"},
+		Queries: []domain.ManifestQuery{{Name: "a_query", Query: "up"}},
+	}
+	legacy := `{"name":"a_query","matrix":{"{pod=\"x\"}":[[1609459200,"1"]]},"end":160945920.05,"fingerprint":"sha256:a"}`
+	gzipped := func(content string) []byte {
+		var buf bytes.Buffer
+		assert.NoError(t, compression.Write(domain.CompressionGzip, &buf, func(w io.Writer) error {
+			_, err := w.Write([]byte(content))
+			return err
+		}))
+		return buf.Bytes()
+	}
+	var v2 bytes.Buffer
+	assert.NoError(t, NewEncoder(&domain.Config{}).Encode(&v2, newMatrix()))
+
+	tests := []struct {
+		name         string
+		key          string
+		data         []byte
+		manifest     *domain.Manifest
+		wantMigrated bool
+		wantRescaled bool
+	}{
+		{name: "legacy", key: "dir/a_query.json", data: []byte(legacy), wantMigrated: true, wantRescaled: true},
+		{name: "legacy with manifest", key: "dir/a_query.json", data: []byte(legacy), manifest: manifest, wantMigrated: true, wantRescaled: true},
+		{name: "legacy gzip", key: "dir/a_query.json.gz", data: gzipped(legacy), wantMigrated: true, wantRescaled: true},
+		{name: "legacy unscaled", key: "dir/a_query.json", data: []byte(`{"name":"a_query","matrix":{},"end":1609459200.5}`), wantMigrated: true},
+		{name: "current schema", key: "dir/a_query.json", data: v2.Bytes()},
+		{name: "not a json result", key: "dir/a_query.parquet", data: []byte("PAR1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrated, rescaled, err := Migrate(tt.key, tt.data, tt.manifest)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantRescaled, rescaled)
+			if !tt.wantMigrated {
+				assert.Nil(t, migrated)
+				return
+			}
+			content, err := compression.Decompress(migrated)
+			assert.NoError(t, err)
+			version, err := jsonSchemaVersion(tt.key, content)
+			assert.NoError(t, err)
+			assert.Equal(t, schemaVersion, version)
+
+			decoded, err := Decode(tt.key, migrated)
+			assert.NoError(t, err)
+			assert.Equal(t, time.UnixMilli(1609459200500).UTC(), decoded.End)
+			if tt.manifest != nil {
+				assert.Equal(t, "up", decoded.Query)
+				assert.Equal(t, tt.manifest.Start, decoded.Start)
+				assert.Equal(t, 15*time.Second, decoded.Step)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package format
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Versions of the json schema. Results without a schema_version are v1.
+const (
+	legacySchemaVersion = 1
+	schemaVersion       = 2
+)
+
+// legacyScaledBefore is the end time in unix seconds below which v1 results are assumed to be stored divided by 10e3
+// instead of 10e2, a bug of versions before v2.0.4. No experiment ended before 2001, when unix time reached 1e9 seconds.
+const legacyScaledBefore = 1e9
+
+// document is a json result in the v2 schema.
+// Times are unix milliseconds with an RFC 3339 copy and the step is in milliseconds.
+type document struct {
+	SchemaVersion int             `json:"schema_version"`
+	Name          string          `json:"name"`
+	Query         string          `json:"query"`
+	ResultType    string          `json:"result_type,omitempty"`
+	Start         int64           `json:"start"`
+	StartTime     string          `json:"start_time"`
+	End           int64           `json:"end"`
+	EndTime       string          `json:"end_time"`
+	Step          int64           `json:"step"`
+	Unit          string          `json:"unit,omitempty"`
+	Fingerprint   string          `json:"fingerprint,omitempty"`
+	Series        []domain.Series `json:"series"`
+}
+
+// legacyDocument is a json result in the v1 schema, keyed by the string of the series labels.
+// The end time is in unix seconds.
+type legacyDocument struct {
+	Name        string                        `json:"name"`
+	Matrix      map[string][]model.SamplePair `json:"matrix"`
+	End         float64                       `json:"end"`
+	Fingerprint string                        `json:"fingerprint,omitempty"`
+}
+
+// encodeJSON writes the matrix in the v2 schema one series at a time, so that the encoded document is never held in memory.
+// The output is identical to json.Marshal of the document.
+func encodeJSON(w io.Writer, m *domain.MetricsMatrix) error {
+	enc := &streamEncoder{w: bufio.NewWriter(w)}
+	enc.raw(`{"schema_version":`)
+	enc.value(schemaVersion)
+	enc.raw(`,"name":`)
+	enc.value(m.Name)
+	enc.raw(`,"query":`)
+	enc.value(m.Query)
+	if m.ResultType != "" {
+		enc.raw(`,"result_type":`)
+		enc.value(m.ResultType)
+	}
+	enc.raw(`,"start":`)
+	enc.value(millis(m.Start))
+	enc.raw(`,"start_time":`)
+	enc.value(formatTime(m.Start))
+	enc.raw(`,"end":`)
+	enc.value(millis(m.End))
+	enc.raw(`,"end_time":`)
+	enc.value(formatTime(m.End))
+	enc.raw(`,"step":`)
+	enc.value(m.Step.Milliseconds())
+	if m.Unit != "" {
+		enc.raw(`,"unit":`)
+		enc.value(m.Unit)
+	}
+	if m.Fingerprint != "" {
+		enc.raw(`,"fingerprint":`)
+		enc.value(m.Fingerprint)
+	}
+	enc.raw(`,"series":[`)
+	for i, series := range m.Series {
+		if i > 0 {
+			enc.raw(",")
+		}
+		enc.value(series)
+	}
+	enc.raw("]}")
+	return enc.flush()
+}
+
+// encodeLegacyJSON writes the matrix in the v1 schema one series at a time.
+// The output is identical to json.Marshal of the legacy document.
+func encodeLegacyJSON(w io.Writer, m *domain.MetricsMatrix) error {
+	series := make(map[string][]model.SamplePair, len(m.Series))
+	keys := make([]string, 0, len(m.Series))
+	for _, s := range m.Series {
+		key := s.Labels.String()
+		series[key] = s.Values
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	enc := &streamEncoder{w: bufio.NewWriter(w)}
+	enc.raw(`{"name":`)
+	enc.value(m.Name)
+	enc.raw(`,"matrix":{`)
+	for i, key := range keys {
+		if i > 0 {
+			enc.raw(",")
+		}
+		enc.value(key)
+		enc.raw(":")
+		enc.value(series[key])
+	}
+	enc.raw(`},"end":`)
+	enc.value(float64(millis(m.End)) / 1e3)
+	if m.Fingerprint != "" {
+		enc.raw(`,"fingerprint":`)
+		enc.value(m.Fingerprint)
+	}
+	enc.raw("}")
+	return enc.flush()
+}
+
+// jsonSchemaVersion reads the schema version of a json result
+func jsonSchemaVersion(key string, content []byte) (int, error) {
+	var version struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(content, &version); err != nil {
+		return 0, fmt.Errorf("Failed to unmarshal %q, %w", key, err)
+	}
+	if version.SchemaVersion == 0 {
+		return legacySchemaVersion, nil
+	}
+	return version.SchemaVersion, nil
+}
+
+// decodeJSON reads a json result in either schema
+func decodeJSON(key string, content []byte) (*domain.MetricsMatrix, error) {
+	version, err := jsonSchemaVersion(key, content)
+	if err != nil {
+		return nil, err
+	}
+	switch version {
+	case legacySchemaVersion:
+		metricsMatrix, _, err := decodeLegacyJSON(key, content)
+		return metricsMatrix, err
+	case schemaVersion:
+	default:
+		return nil, fmt.Errorf("Unsupported schema version %d of %q", version, key)
+	}
+
+	var decoded document
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal %q, %w", key, err)
+	}
+	return &domain.MetricsMatrix{
+		Name:        decoded.Name,
+		Query:       decoded.Query,
+		ResultType:  domain.ResultType(decoded.ResultType),
+		Start:       fromMillis(decoded.Start),
+		End:         fromMillis(decoded.End),
+		Step:        time.Duration(decoded.Step) * time.Millisecond,
+		Unit:        decoded.Unit,
+		Fingerprint: decoded.Fingerprint,
+		Series:      decoded.Series,
+	}, nil
+}
+
+// decodeLegacyJSON reads a json result in the v1 schema and reports whether its end time had the legacy scaling.
+// The labels are parsed from the series keys.
+func decodeLegacyJSON(key string, content []byte) (*domain.MetricsMatrix, bool, error) {
+	var decoded legacyDocument
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		return nil, false, fmt.Errorf("Failed to unmarshal %q, %w", key, err)
+	}
+	end, rescaled := legacyEnd(decoded.End)
+	metricsMatrix := &domain.MetricsMatrix{
+		Name:        decoded.Name,
+		End:         end,
+		Fingerprint: decoded.Fingerprint,
+	}
+
+	seriesKeys := make([]string, 0, len(decoded.Matrix))
+	for seriesKey := range decoded.Matrix {
+		seriesKeys = append(seriesKeys, seriesKey)
+	}
+	slices.Sort(seriesKeys)
+	for _, seriesKey := range seriesKeys {
+		metric, err := parseLabels(seriesKey)
+		if err != nil {
+			return nil, false, fmt.Errorf("Failed to decode %q, %w", key, err)
+		}
+		metricsMatrix.Series = append(metricsMatrix.Series, domain.Series{Labels: metric, Values: decoded.Matrix[seriesKey]})
+	}
+	return metricsMatrix, rescaled, nil
+}
+
+// legacyEnd converts the end time of a v1 result from unix seconds,
+// correcting the legacy scaling and reporting whether it was applied
+func legacyEnd(end float64) (time.Time, bool) {
+	if end == 0 {
+		return time.Time{}, false
+	}
+	rescaled := end < legacyScaledBefore
+	if rescaled {
+		end *= 10
+	}
+	return fromMillis(int64(math.Round(end * 1e3))), rescaled
+}
+
+// parseLabels parses the labels of a v1 series key, the string of a model.Metric
+func parseLabels(seriesKey string) (model.Metric, error) {
+	parsed, err := parser.ParseMetric(seriesKey)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the labels of series %q, %w", seriesKey, err)
+	}
+	metric := make(model.Metric, parsed.Len())
+	parsed.Range(func(label labels.Label) {
+		metric[model.LabelName(label.Name)] = model.LabelValue(label.Value)
+	})
+	return metric, nil
+}
+
+// millis returns t in unix milliseconds, or 0 for the zero time
+func millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// fromMillis is the inverse of millis
+func fromMillis(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis).UTC()
+}
+
+// formatTime returns the RFC 3339 copy of t, or an empty string for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timeLayout)
+}
+
+// streamEncoder writes json fragments and keeps the first error
+type streamEncoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (e *streamEncoder) raw(s string) {
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *streamEncoder) value(v any) {
+	if e.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		e.err = err
+		return
+	}
+	_, e.err = e.w.Write(data)
+}
+
+func (e *streamEncoder) flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
@@ -0,0 +1,149 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix domain.MetricsMatrix
+	}{
+		{name: "no series", matrix: domain.MetricsMatrix{Name: "a", End: time.UnixMilli(1500)}},
+		{
+			name: "series",
+			matrix: domain.MetricsMatrix{
+				Name:       "histogram_<bucket>",
+				Query:      `histogram_quantile(0.5, rate(a_bucket{pod="x&y"}[1m]))`,
+				ResultType: domain.ResultTypeMatrix,
+				Start:      time.UnixMilli(1609455600000),
+				End:        time.UnixMilli(1609459200500),
+				Step:       1500 * time.Millisecond,
+				Unit:       "seconds",
+				Series: []domain.Series{
+					{Labels: model.Metric{"le": "+Inf", "pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}, {Timestamp: 2000, Value: model.SampleValue(math.NaN())}}},
+					{Labels: model.Metric{"le": "0.5", "pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 0.25}}},
+					{Labels: model.Metric{"le": "1", "pod": "x&y"}},
+				},
+				Fingerprint: "sha256:a",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := tt.matrix.Series
+			if series == nil {
+				series = []domain.Series{}
+			}
+			legacy := map[string][]model.SamplePair{}
+			for _, s := range tt.matrix.Series {
+				legacy[s.Labels.String()] = s.Values
+			}
+			documents := []struct {
+				encode   func(io.Writer, *domain.MetricsMatrix) error
+				document any
+			}{
+				{encode: encodeJSON, document: document{
+					SchemaVersion: schemaVersion,
+					Name:          tt.matrix.Name,
+					Query:         tt.matrix.Query,
+					ResultType:    string(tt.matrix.ResultType),
+					Start:         millis(tt.matrix.Start),
+					StartTime:     formatTime(tt.matrix.Start),
+					End:           millis(tt.matrix.End),
+					EndTime:       formatTime(tt.matrix.End),
+					Step:          tt.matrix.Step.Milliseconds(),
+					Unit:          tt.matrix.Unit,
+					Fingerprint:   tt.matrix.Fingerprint,
+					Series:        series,
+				}},
+				{encode: encodeLegacyJSON, document: legacyDocument{
+					Name:        tt.matrix.Name,
+					Matrix:      legacy,
+					End:         float64(millis(tt.matrix.End)) / 1e3,
+					Fingerprint: tt.matrix.Fingerprint,
+				}},
+			}
+			for _, d := range documents {
+				expected, err := json.Marshal(d.document)
+				assert.NoError(t, err)
+
+				var buf bytes.Buffer
+				checksum := domain.NewChecksumWriter()
+				assert.NoError(t, d.encode(io.MultiWriter(&buf, checksum), &tt.matrix))
+				assert.Equal(t, string(expected), buf.String())
+				assert.Equal(t, domain.Checksum(expected), checksum.Checksum())
+				assert.Equal(t, int64(len(expected)), checksum.Bytes())
+			}
+		})
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	v2 := `{"schema_version":2,"name":"a","query":"up","result_type":"matrix","start":1609455600000,"start_time":"2021-01-01T00:00:00.000Z",` +
+		`"end":1609459200500,"end_time":"2021-01-01T01:00:00.500Z","step":15000,"unit":"bytes","fingerprint":"sha256:a",` +
+		`"series":[{"labels":{},"values":[[1,"1"]]},{"labels":{"pod":"x"},"values":[[1,"2"]]}]}`
+	decoded, err := decodeJSON("dir/a.json", []byte(v2))
+	assert.NoError(t, err)
+	assert.Equal(t, &domain.MetricsMatrix{
+		Name:        "a",
+		Query:       "up",
+		ResultType:  domain.ResultTypeMatrix,
+		Start:       time.UnixMilli(1609455600000).UTC(),
+		End:         time.UnixMilli(1609459200500).UTC(),
+		Step:        15 * time.Second,
+		Unit:        "bytes",
+		Fingerprint: "sha256:a",
+		Series: []domain.Series{
+			{Labels: model.Metric{}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}},
+			{Labels: model.Metric{"pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 2}}},
+		},
+	}, decoded)
+
+	// labels of legacy results are parsed from the series keys
+	v1 := `{"name":"a","matrix":{"{pod=\"x\"}":[[1,"2"]],"up{le=\"+Inf\", pod=\"x&y\"}":[[1,"1"]]},"end":1609459200.5}`
+	decoded, err = decodeJSON("dir/a.json", []byte(v1))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", decoded.Name)
+	assert.Equal(t, time.UnixMilli(1609459200500).UTC(), decoded.End)
+	assert.Equal(t, []domain.Series{
+		{Labels: model.Metric{"__name__": "up", "le": "+Inf", "pod": "x&y"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}},
+		{Labels: model.Metric{"pod": "x"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 2}}},
+	}, decoded.Series)
+
+	_, err = decodeJSON("dir/a.json", []byte(`{"name":"a","matrix":{"not a series":[]}}`))
+	assert.ErrorContains(t, err, "not a series")
+	_, err = decodeJSON("dir/a.json", []byte(`{"schema_version":3,"name":"a"}`))
+	assert.ErrorContains(t, err, "Unsupported schema version 3")
+	_, err = decodeJSON("dir/a.json", []byte("{"))
+	assert.Error(t, err)
+}
+
+func TestLegacyEnd(t *testing.T) {
+	tests := []struct {
+		name         string
+		end          float64
+		wantEnd      time.Time
+		wantRescaled bool
+	}{
+		{name: "missing", end: 0, wantEnd: time.Time{}},
+		{name: "seconds", end: 1609459200.5, wantEnd: time.UnixMilli(1609459200500).UTC()},
+		{name: "divided by 10e3", end: 160945920.05, wantEnd: time.UnixMilli(1609459200500).UTC(), wantRescaled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end, rescaled := legacyEnd(tt.end)
+			assert.Equal(t, tt.wantEnd, end)
+			assert.Equal(t, tt.wantRescaled, rescaled)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress/gzip"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+	"github.com/prometheus/common/model"
+)
+
+// Fixed columns of a parquet result. Every other column holds the values of a label.
+const (
+	queryNameColumn = "query_name"
+	timestampColumn = "timestamp"
+	valueColumn     = "value"
+)
+
+// Key value metadata of a parquet result.
+// Times are RFC 3339 and the step is a Go duration.
+const (
+	nameMetadata        = "metrics-processor.name"
+	queryMetadata       = "metrics-processor.query"
+	resultTypeMetadata  = "metrics-processor.result_type"
+	startMetadata       = "metrics-processor.start"
+	endMetadata         = "metrics-processor.end"
+	stepMetadata        = "metrics-processor.step"
+	unitMetadata        = "metrics-processor.unit"
+	fingerprintMetadata = "metrics-processor.fingerprint"
+	// labelsMetadata maps label columns to label names, as json
+	labelsMetadata = "metrics-processor.labels"
+)
+
+// rowGroupSize is the number of rows buffered before a row group is written out
+const rowGroupSize = 1 << 16
+
+// encodeParquet writes the matrix as a parquet file with one row per sample.
+// Pages are compressed with the codec of the storage compression.
+func encodeParquet(w io.Writer, metricsMatrix *domain.MetricsMatrix, compression domain.Compression) error {
+	columns := labelColumns(metricsMatrix.Series)
+	labels, err := json.Marshal(columns)
+	if err != nil {
+		return err
+	}
+
+	group := parquet.Group{
+		queryNameColumn: parquet.String(),
+		timestampColumn: parquet.Timestamp(parquet.Millisecond),
+		valueColumn:     parquet.Leaf(parquet.DoubleType),
+	}
+	for column := range columns {
+		group[column] = parquet.Optional(parquet.String())
+	}
+	schema := parquet.NewSchema(metricsMatrix.Name, group)
+
+	options := []parquet.WriterOption{
+		schema,
+		parquet.KeyValueMetadata(nameMetadata, metricsMatrix.Name),
+		parquet.KeyValueMetadata(queryMetadata, metricsMatrix.Query),
+		parquet.KeyValueMetadata(resultTypeMetadata, string(metricsMatrix.ResultType)),
+		parquet.KeyValueMetadata(stepMetadata, metricsMatrix.Step.String()),
+		parquet.KeyValueMetadata(unitMetadata, metricsMatrix.Unit),
+		parquet.KeyValueMetadata(fingerprintMetadata, metricsMatrix.Fingerprint),
+		parquet.KeyValueMetadata(labelsMetadata, string(labels)),
+	}
+	if !metricsMatrix.Start.IsZero() {
+		options = append(options, parquet.KeyValueMetadata(startMetadata, metricsMatrix.Start.UTC().Format(time.RFC3339Nano)))
+	}
+	if !metricsMatrix.End.IsZero() {
+		options = append(options, parquet.KeyValueMetadata(endMetadata, metricsMatrix.End.UTC().Format(time.RFC3339Nano)))
+	}
+	switch compression {
+	case domain.CompressionGzip:
+		options = append(options, parquet.Compression(&gzip.Codec{}))
+	case domain.CompressionZstd:
+		options = append(options, parquet.Compression(&zstd.Codec{}))
+	}
+	writer := parquet.NewWriter(w, options...)
+
+	// leaf columns are ordered by name
+	paths := schema.Columns()
+	buffered := 0
+	for _, series := range metricsMatrix.Series {
+		rows := make([]parquet.Row, 0, len(series.Values))
+		for _, sample := range series.Values {
+			row := make(parquet.Row, len(paths))
+			for i, path := range paths {
+				switch column := path[0]; column {
+				case queryNameColumn:
+					row[i] = parquet.ByteArrayValue([]byte(metricsMatrix.Name)).Level(0, 0, i)
+				case timestampColumn:
+					row[i] = parquet.Int64Value(int64(sample.Timestamp)).Level(0, 0, i)
+				case valueColumn:
+					row[i] = parquet.DoubleValue(float64(sample.Value)).Level(0, 0, i)
+				default:
+					if value, ok := series.Labels[model.LabelName(columns[column])]; ok {
+						row[i] = parquet.ByteArrayValue([]byte(value)).Level(0, 1, i)
+					} else {
+						row[i] = parquet.NullValue().Level(0, 0, i)
+					}
+				}
+			}
+			rows = append(rows, row)
+		}
+		if _, err := writer.WriteRows(rows); err != nil {
+			return fmt.Errorf("Failed to write parquet rows, %w", err)
+		}
+		buffered += len(rows)
+		if buffered >= rowGroupSize {
+			if err := writer.Flush(); err != nil {
+				return fmt.Errorf("Failed to write parquet row group, %w", err)
+			}
+			buffered = 0
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("Failed to write parquet file, %w", err)
+	}
+	return nil
+}
+
+// labelColumns maps the column of every label of the series to the label name.
+// Labels clashing with a fixed column or with another label column are prefixed with label_.
+func labelColumns(series []domain.Series) map[string]string {
+	seen := map[model.LabelName]bool{}
+	var names []string
+	for _, s := range series {
+		for name := range s.Labels {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, string(name))
+			}
+		}
+	}
+	slices.Sort(names)
+
+	columns := map[string]string{
+		queryNameColumn: "",
+		timestampColumn: "",
+		valueColumn:     "",
+	}
+	for _, name := range names {
+		column := name
+		for {
+			if _, taken := columns[column]; !taken {
+				break
+			}
+			column = "label_" + column
+		}
+		columns[column] = name
+	}
+	delete(columns, queryNameColumn)
+	delete(columns, timestampColumn)
+	delete(columns, valueColumn)
+	return columns
+}
+
+// decodeParquet reads a matrix written by encodeParquet
+func decodeParquet(key string, data []byte) (*domain.MetricsMatrix, error) {
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open parquet file %q, %w", key, err)
+	}
+	metricsMatrix := &domain.MetricsMatrix{}
+	metricsMatrix.Name, _ = file.Lookup(nameMetadata)
+	metricsMatrix.Query, _ = file.Lookup(queryMetadata)
+	resultType, _ := file.Lookup(resultTypeMetadata)
+	metricsMatrix.ResultType = domain.ResultType(resultType)
+	metricsMatrix.Unit, _ = file.Lookup(unitMetadata)
+	metricsMatrix.Fingerprint, _ = file.Lookup(fingerprintMetadata)
+	for metadata, t := range map[string]*time.Time{startMetadata: &metricsMatrix.Start, endMetadata: &metricsMatrix.End} {
+		if value, ok := file.Lookup(metadata); ok {
+			if *t, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				return nil, fmt.Errorf("Invalid %s in parquet file %q, %w", metadata, key, err)
+			}
+		}
+	}
+	if step, ok := file.Lookup(stepMetadata); ok {
+		if metricsMatrix.Step, err = time.ParseDuration(step); err != nil {
+			return nil, fmt.Errorf("Invalid step %q in parquet file %q, %w", step, key, err)
+		}
+	}
+	columns := map[string]string{}
+	if labels, ok := file.Lookup(labelsMetadata); ok {
+		if err := json.Unmarshal([]byte(labels), &columns); err != nil {
+			return nil, fmt.Errorf("Invalid labels in parquet file %q, %w", key, err)
+		}
+	}
+
+	// rows of a series are consecutive, but are grouped by labels to read files written by other tools too
+	index := map[string]int{}
+	paths := file.Schema().Columns()
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+	rows := make([]parquet.Row, 1024)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			metric := model.Metric{}
+			var sample model.SamplePair
+			for _, value := range row {
+				switch column := paths[value.Column()][0]; column {
+				case queryNameColumn:
+					if metricsMatrix.Name == "" {
+						metricsMatrix.Name = value.String()
+					}
+				case timestampColumn:
+					sample.Timestamp = model.Time(value.Int64())
+				case valueColumn:
+					sample.Value = model.SampleValue(value.Double())
+				default:
+					if value.IsNull() {
+						continue
+					}
+					name, ok := columns[column]
+					if !ok {
+						name = column
+					}
+					metric[model.LabelName(name)] = model.LabelValue(value.String())
+				}
+			}
+			seriesKey := metric.String()
+			i, ok := index[seriesKey]
+			if !ok {
+				i = len(metricsMatrix.Series)
+				index[seriesKey] = i
+				metricsMatrix.Series = append(metricsMatrix.Series, domain.Series{Labels: metric})
+			}
+			metricsMatrix.Series[i].Values = append(metricsMatrix.Series[i].Values, sample)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read parquet file %q, %w", key, err)
+		}
+	}
+	return metricsMatrix, nil
+}
@@ -2,9 +2,12 @@ package prometheus
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -18,6 +21,9 @@ import (
 type PrometheusAdapter struct {
 	client     v1.API
 	queryRange v1.Range
+	retry      RetryConfig
+	schedule   ScheduleConfig
+	split      SplitConfig
 	queries    []*promql.Query
 }
 
@@ -29,7 +35,7 @@ func NewPrometheusAdapter(config *domain.Config) (*PrometheusAdapter, error) {
 		return nil, err
 	}
 
-	start := config.EndTime.Add(-1 * config.Duration)
+	start := config.StartTime()
 
 	slog.Info("Query Range set.", "start", start, "end", config.EndTime)
 
@@ -40,6 +46,20 @@ func NewPrometheusAdapter(config *domain.Config) (*PrometheusAdapter, error) {
 			End:   config.EndTime,
 			Step:  config.Step,
 		},
+		retry: RetryConfig{
+			Timeout:    config.QueryTimeout,
+			Retries:    config.QueryRetries,
+			Backoff:    config.QueryBackoff,
+			MaxBackoff: config.QueryMaxBackoff,
+		},
+		schedule: ScheduleConfig{
+			Parallelism: config.QueryParallelism,
+			RateLimit:   config.QueryRateLimit,
+		},
+		split: SplitConfig{
+			MaxPoints:  config.QueryMaxPoints,
+			MaxSamples: config.QueryMaxSamples,
+		},
 	}, nil
 }
 
@@ -47,43 +67,132 @@ func (pa *PrometheusAdapter) RegisterQuery(query *promql.Query) {
 	pa.queries = append(pa.queries, query)
 }
 
+// Retain keeps only the registered queries for which keep returns true
+func (pa *PrometheusAdapter) Retain(keep func(query *promql.Query) bool) {
+	pa.queries = slices.DeleteFunc(pa.queries, func(query *promql.Query) bool {
+		return !keep(query)
+	})
+}
+
 func (pa *PrometheusAdapter) Len() int {
 	return len(pa.queries)
 }
 
+// Plan describes the registered queries with the fingerprint of their query text and range
+func (pa *PrometheusAdapter) Plan() []domain.QueryResult {
+	plan := make([]domain.QueryResult, 0, len(pa.queries))
+	for _, query := range pa.queries {
+		plan = append(plan, domain.QueryResult{
+			Name:        query.Name,
+			Query:       query.AsString(),
+			Fingerprint: pa.fingerprint(query),
+		})
+	}
+	return plan
+}
+
+// Skip removes the named queries
+func (pa *PrometheusAdapter) Skip(names []string) {
+	pa.Retain(func(query *promql.Query) bool {
+		return !slices.Contains(names, query.Name)
+	})
+}
+
+func (pa *PrometheusAdapter) fingerprint(query *promql.Query) string {
+	queryRange := pa.evaluationRange(query)
+	return domain.Fingerprint(query.AsString(), queryRange.Start, queryRange.End, queryRange.Step)
+}
+
+// evaluationRange returns the range the query is evaluated over.
+// Instant queries are evaluated once at the end of the query range.
+func (pa *PrometheusAdapter) evaluationRange(query *promql.Query) v1.Range {
+	if query.Mode() == promql.ModeInstant {
+		return v1.Range{Start: pa.queryRange.End, End: pa.queryRange.End}
+	}
+	return pa.queryRange
+}
+
+// Queries returns the registered queries
+func (pa *PrometheusAdapter) Queries() []*promql.Query {
+	return pa.queries
+}
+
 func (pa *PrometheusAdapter) PrintQuery() {
 	for _, query := range pa.queries {
 		fmt.Printf("%s: %s\n", query.Name, query.AsString())
 	}
 }
 
-func (pa *PrometheusAdapter) Query(metricsChan chan<- *domain.MetricsMatrix) {
-	var wg sync.WaitGroup
+// Validate validates every registered query and returns all problems found
+func (pa *PrometheusAdapter) Validate() error {
+	var errs []error
+	for _, query := range pa.queries {
+		if err := query.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
 
+// ValidateRanges checks that the range selectors of every registered query span at least min
+func (pa *PrometheusAdapter) ValidateRanges(min time.Duration) error {
+	var errs []error
 	for _, query := range pa.queries {
+		if err := query.ValidateRanges(min); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Query runs the registered queries on a bounded pool of workers.
+// Queries are dispatched in order of priority and cost, optionally rate limited.
+func (pa *PrometheusAdapter) Query(metricsChan chan<- *domain.MetricsMatrix, report *domain.RunReport) {
+	var wg sync.WaitGroup
+	queries := pa.scheduledQueries()
+	queryChan := make(chan *promql.Query)
+
+	for range pa.schedule.workers(len(queries)) {
 		wg.Add(1)
-		go func(q *promql.Query) {
+		go func() {
 			defer wg.Done()
-			pa.runQuery(q, metricsChan)
-		}(query)
+			for q := range queryChan {
+				pa.runQuery(q, metricsChan, report)
+			}
+		}()
 	}
 
+	go func() {
+		limiter := newRateLimiter(pa.schedule.RateLimit)
+		for _, query := range queries {
+			limiter.Wait()
+			queryChan <- query
+		}
+		close(queryChan)
+	}()
+
 	go func() {
 		wg.Wait()
 		close(metricsChan)
 	}()
 }
 
-func (pa *PrometheusAdapter) runQuery(query *promql.Query, metricsChan chan<- *domain.MetricsMatrix) {
-	slog.Info("Running Query.", "name", query.Name, "query", query.AsString())
-	result, warnings, err := pa.client.QueryRange(
-		context.Background(),
-		query.AsString(),
-		pa.queryRange,
-		v1.WithTimeout(5*time.Second),
-	)
+func (pa *PrometheusAdapter) runQuery(query *promql.Query, metricsChan chan<- *domain.MetricsMatrix, report *domain.RunReport) {
+	slog.Info("Running Query.", "name", query.Name, "query", query.AsString(), "mode", query.Mode())
+	start := time.Now()
+	queryResult := domain.QueryResult{Name: query.Name, Query: query.AsString(), Fingerprint: pa.fingerprint(query)}
+	var result model.Value
+	var warnings v1.Warnings
+	var err error
+	if query.Mode() == promql.ModeInstant {
+		result, warnings, err = pa.queryInstantWithRetry(query, &queryResult)
+	} else {
+		result, warnings, err = pa.queryRangeSplit(query, &queryResult)
+	}
+	queryResult.Duration = time.Since(start)
 	if err != nil {
-		slog.Error("Query failed", "name", query.Name, "error", err, "query", query.AsString())
+		slog.Error("Query failed", "name", query.Name, "error", err, "attempts", queryResult.Attempts, "query", query.AsString())
+		report.RecordQuery(queryResult, err)
 		return
 	}
 
@@ -91,21 +200,197 @@ func (pa *PrometheusAdapter) runQuery(query *promql.Query, metricsChan chan<- *d
 		slog.Warn(warning)
 	}
 
-	if matrix, ok := result.(model.Matrix); ok {
-		metricsChan <- pa.handleMatrixResult(query.Name, &matrix, pa.queryRange.End)
-	} else {
-		slog.Warn("Query did not return matrix. Skipping.", "name", query.Name, "query", query.AsString())
+	matrix, resultType, err := resultMatrix(query, result)
+	if err != nil {
+		slog.Warn("Query returned an unsupported result. Skipping.", "name", query.Name, "query", query.AsString(), "error", err)
+		report.RecordQuery(queryResult, err)
+		return
+	}
+
+	queryResult.Series = len(matrix)
+	queryResult.Samples = countSamples(matrix)
+	report.RecordQuery(queryResult, nil)
+	metricsMatrix := pa.handleMatrixResult(query, matrix)
+	metricsMatrix.ResultType = resultType
+	metricsMatrix.Fingerprint = queryResult.Fingerprint
+	metricsChan <- metricsMatrix
+}
+
+// resultMatrix converts the result of the query to a matrix.
+// Range queries must return a matrix. Vectors and scalars of instant queries become series with a single sample.
+func resultMatrix(query *promql.Query, result model.Value) (model.Matrix, domain.ResultType, error) {
+	if query.Mode() != promql.ModeInstant {
+		matrix, ok := result.(model.Matrix)
+		if !ok {
+			return nil, "", fmt.Errorf("expected matrix result, got %s", result.Type())
+		}
+		return matrix, domain.ResultTypeMatrix, nil
+	}
+
+	switch value := result.(type) {
+	case model.Matrix:
+		return value, domain.ResultTypeMatrix, nil
+	case model.Vector:
+		matrix := make(model.Matrix, 0, len(value))
+		for _, sample := range value {
+			matrix = append(matrix, &model.SampleStream{
+				Metric: sample.Metric,
+				Values: []model.SamplePair{{Timestamp: sample.Timestamp, Value: sample.Value}},
+			})
+		}
+		return matrix, domain.ResultTypeVector, nil
+	case *model.Scalar:
+		return model.Matrix{{
+			Metric: model.Metric{},
+			Values: []model.SamplePair{{Timestamp: value.Timestamp, Value: value.Value}},
+		}}, domain.ResultTypeScalar, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported %s result", result.Type())
+	}
+}
+
+// queryRangeSplit runs the range query in chunks that respect the point and sample limits
+// and stitches the resulting matrices together.
+func (pa *PrometheusAdapter) queryRangeSplit(query *promql.Query, queryResult *domain.QueryResult) (model.Value, v1.Warnings, error) {
+	series := 1
+	if pa.split.MaxSamples > 0 && pa.queryRange.Step > 0 {
+		series = pa.probeSeries(query)
+	}
+	points := pa.split.chunkPoints(series)
+	if pa.queryRange.Step <= 0 || !pa.queryRange.Start.Add(time.Duration(points-1)*pa.queryRange.Step).Before(pa.queryRange.End) {
+		return pa.queryRangeWithRetry(query, pa.queryRange, queryResult)
+	}
+
+	stitched := newStitcher()
+	var allWarnings v1.Warnings
+	for start := pa.queryRange.Start; !start.After(pa.queryRange.End); {
+		chunk := nextChunk(pa.queryRange, start, points)
+		slog.Info("Running Query chunk.", "name", query.Name, "start", chunk.Start, "end", chunk.End)
+		result, warnings, err := pa.queryRangeWithRetry(query, chunk, queryResult)
+		allWarnings = append(allWarnings, warnings...)
+		if err != nil {
+			return nil, allWarnings, fmt.Errorf("chunk %s - %s: %w", chunk.Start, chunk.End, err)
+		}
+		matrix, ok := result.(model.Matrix)
+		if !ok {
+			return nil, allWarnings, fmt.Errorf("expected matrix result, got %s", result.Type())
+		}
+		stitched.add(matrix)
+
+		start = chunk.End.Add(pa.queryRange.Step)
+		points = pa.split.chunkPoints(max(series, stitched.series()))
+	}
+
+	return stitched.matrix(), allWarnings, nil
+}
+
+// probeSeries returns the number of series the query returns at the start of the query range,
+// used to size the first chunk. Returns 1 when the probe fails.
+func (pa *PrometheusAdapter) probeSeries(query *promql.Query) int {
+	timeout := pa.retry.Timeout
+	if query.Timeout() > 0 {
+		timeout = query.Timeout()
+	}
+	result, _, err := queryOnce(func(ctx context.Context, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+		return pa.client.Query(ctx, query.AsString(), pa.queryRange.Start, opts...)
+	}, timeout)
+	if err != nil {
+		slog.Warn("Failed to probe series count. Sizing the first chunk for a single series.", "name", query.Name, "error", err)
+		return 1
+	}
+	vector, ok := result.(model.Vector)
+	if !ok {
+		return 1
+	}
+	return max(len(vector), 1)
+}
+
+// queryRangeWithRetry runs the range query, retrying retryable failures with backoff.
+// Every attempt is counted in queryResult.
+func (pa *PrometheusAdapter) queryRangeWithRetry(query *promql.Query, queryRange v1.Range, queryResult *domain.QueryResult) (model.Value, v1.Warnings, error) {
+	return pa.queryWithRetry(query, queryResult, func(ctx context.Context, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+		return pa.client.QueryRange(ctx, query.AsString(), queryRange, opts...)
+	})
+}
+
+// queryInstantWithRetry evaluates the instant query at the end of the query range, retrying retryable failures with backoff.
+// Every attempt is counted in queryResult.
+func (pa *PrometheusAdapter) queryInstantWithRetry(query *promql.Query, queryResult *domain.QueryResult) (model.Value, v1.Warnings, error) {
+	return pa.queryWithRetry(query, queryResult, func(ctx context.Context, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+		return pa.client.Query(ctx, query.AsString(), pa.queryRange.End, opts...)
+	})
+}
+
+// queryWithRetry runs the query with run, retrying retryable failures with backoff.
+// Timeout and retries of the query override the adapter settings when set.
+// Every attempt is counted in queryResult.
+func (pa *PrometheusAdapter) queryWithRetry(query *promql.Query, queryResult *domain.QueryResult, run queryFunc) (model.Value, v1.Warnings, error) {
+	timeout := pa.retry.Timeout
+	if query.Timeout() > 0 {
+		timeout = query.Timeout()
+	}
+	retries := pa.retry.Retries
+	if override, ok := query.Retries(); ok {
+		retries = override
+	}
+
+	for attempt := 0; ; attempt++ {
+		queryResult.Attempts++
+		result, warnings, err := queryOnce(run, timeout)
+		if err == nil {
+			return result, warnings, nil
+		}
+		if attempt >= retries || !isRetryable(err) {
+			return nil, warnings, err
+		}
+
+		wait := pa.retry.backoff(attempt)
+		slog.Warn("Query attempt failed. Retrying.", "name", query.Name, "attempt", attempt+1, "error", err, "backoff", wait)
+		time.Sleep(wait)
+	}
+}
+
+// queryFunc runs a single attempt of a query with the options of the attempt
+type queryFunc func(ctx context.Context, opts ...v1.Option) (model.Value, v1.Warnings, error)
+
+func queryOnce(run queryFunc, timeout time.Duration) (model.Value, v1.Warnings, error) {
+	ctx := context.Background()
+	var opts []v1.Option
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		opts = append(opts, v1.WithTimeout(timeout))
+	}
+	return run(ctx, opts...)
+}
+
+func countSamples(matrix model.Matrix) int {
+	samples := 0
+	for _, sampleStream := range matrix {
+		samples += len(sampleStream.Values)
 	}
+	return samples
 }
 
-func (pa *PrometheusAdapter) handleMatrixResult(name string, matrix *model.Matrix, end time.Time) *domain.MetricsMatrix {
+// handleMatrixResult converts the result of the query to a matrix with one series per stream, ordered by labels
+func (pa *PrometheusAdapter) handleMatrixResult(query *promql.Query, matrix model.Matrix) *domain.MetricsMatrix {
+	sort.Sort(matrix)
+	queryRange := pa.evaluationRange(query)
 	metricsMatrix := domain.MetricsMatrix{
-		Name:   name,
-		Matrix: make(map[string][]model.SamplePair),
-		End:    float64(end.UnixMilli()) / 10e2,
+		Name:   query.Name,
+		Query:  query.AsString(),
+		Start:  queryRange.Start,
+		End:    queryRange.End,
+		Step:   queryRange.Step,
+		Unit:   query.Unit(),
+		Series: make([]domain.Series, 0, len(matrix)),
 	}
-	for _, sampleStream := range *matrix {
-		metricsMatrix.Matrix[sampleStream.Metric.String()] = sampleStream.Values
+	for _, sampleStream := range matrix {
+		metricsMatrix.Series = append(metricsMatrix.Series, domain.Series{
+			Labels: sampleStream.Metric,
+			Values: sampleStream.Values,
+		})
 	}
 
 	return &metricsMatrix
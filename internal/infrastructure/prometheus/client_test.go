@@ -1,64 +1,295 @@
 package prometheus
 
 import (
-	"context"
-	/* "encoding/json" */
-	"log/slog"
-	/* "os" */
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/prometheus/prometheustest"
+	"github.com/hanapedia/metrics-processor/pkg/promql"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
 )
 
-func TestNewPrometheusAdapter(t *testing.T) {
-	// Test for successful client creation
-	cfg := &domain.Config{
-		MetricsQueryEndpoint: "http://localhost:9091",
-		EndTime:              time.Now(),
-		Duration:             30 * time.Minute,
-		Step:                 15 * time.Second,
-	}
+var testEnd = time.Date(2021, 1, 1, 1, 0, 0, 0, time.UTC)
 
-	adapter, err := NewPrometheusAdapter(cfg)
+// newTestAdapter creates an adapter querying the last 30 minutes before testEnd from api
+func newTestAdapter(t *testing.T, api *prometheustest.API, config domain.Config) *PrometheusAdapter {
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	config.MetricsQueryEndpoint = server.URL
+	config.EndTime = testEnd
+	config.Duration = 30 * time.Minute
+	config.Step = 15 * time.Second
+	adapter, err := NewPrometheusAdapter(&config)
 	if err != nil {
-		slog.Error("failed to initialize prometheus adapter")
-		t.Fail()
+		t.Fatal(err)
 	}
+	return adapter
+}
 
-	query := "sum by (deployment)(label_replace(rate(container_cpu_usage_seconds_total{namespace=\"emulation\",container=~\"server|redis\"}[1m0s]),\"deployment\",\"$1\",\"pod\",\"(.*)-[^-]+-[^-]+\")) / sum by (deployment)(label_replace(kube_pod_container_resource_limits{namespace=\"emulation\",container=~\"server|redis\",resource=\"cpu\"},\"deployment\",\"$1\",\"pod\",\"(.*)-[^-]+-[^-]+\"))"
+// runQueries runs the registered queries and returns the results by name
+func runQueries(adapter *PrometheusAdapter) (map[string]*domain.MetricsMatrix, *domain.RunReport) {
+	report := domain.NewRunReport()
+	metricsChan := make(chan *domain.MetricsMatrix)
+	adapter.Query(metricsChan, report)
+	results := map[string]*domain.MetricsMatrix{}
+	for metricsMatrix := range metricsChan {
+		results[metricsMatrix.Name] = metricsMatrix
+	}
+	return results, report
+}
 
-	result, warnings, err := adapter.client.QueryRange(
-		context.Background(),
-		query,
-		adapter.queryRange,
-		v1.WithTimeout(5*time.Second),
-	)
-	if err != nil {
-		slog.Error("Query failed")
-		t.Fail()
+func samples(start, end time.Time, step time.Duration, value float64) []model.SamplePair {
+	var values []model.SamplePair
+	for t := start; !t.After(end); t = t.Add(step) {
+		values = append(values, model.SamplePair{Timestamp: model.TimeFromUnixNano(t.UnixNano()), Value: model.SampleValue(value)})
+	}
+	return values
+}
+
+func TestQuery(t *testing.T) {
+	// the fixture covers more than the queried range, in reverse label order
+	fixture := model.Matrix{
+		{Metric: model.Metric{"pod": "b"}, Values: samples(testEnd.Add(-2*time.Hour), testEnd, 15*time.Second, 2)},
+		{Metric: model.Metric{"pod": "a"}, Values: samples(testEnd.Add(-2*time.Hour), testEnd, 15*time.Second, 1)},
 	}
+	api := prometheustest.NewAPI().SetFixture("up", fixture)
+	adapter := newTestAdapter(t, api, domain.Config{QueryParallelism: 2})
+	adapter.RegisterQuery(promql.NewQuery("up").SetName("up"))
+	synthetic := promql.NewQuery(`sum by (deployment) (rate(requests_total[1m]))`).SetName("requests").SetUnit("requests/s")
+	adapter.RegisterQuery(synthetic)
+
+	results, report := runQueries(adapter)
+	assert.Len(t, results, 2)
 
-	for _, warning := range warnings {
-		slog.Warn(warning)
+	up := results["up"]
+	assert.Equal(t, "up", up.Query)
+	assert.Equal(t, testEnd.Add(-30*time.Minute), up.Start)
+	assert.Equal(t, testEnd, up.End)
+	assert.Equal(t, 15*time.Second, up.Step)
+	assert.Equal(t, domain.ResultTypeMatrix, up.ResultType)
+	assert.Equal(t, adapter.fingerprint(adapter.Queries()[0]), up.Fingerprint)
+	assert.Equal(t, []domain.Series{
+		{Labels: model.Metric{"pod": "a"}, Values: samples(testEnd.Add(-30*time.Minute), testEnd, 15*time.Second, 1)},
+		{Labels: model.Metric{"pod": "b"}, Values: samples(testEnd.Add(-30*time.Minute), testEnd, 15*time.Second, 2)},
+	}, up.Series)
+
+	requests := results["requests"]
+	assert.Equal(t, "requests/s", requests.Unit)
+	assert.Len(t, requests.Series, 3)
+	for _, series := range requests.Series {
+		assert.Contains(t, series.Labels, model.LabelName("deployment"))
+		assert.Len(t, series.Values, 121)
 	}
 
-	if matrix, ok := result.(model.Matrix); ok {
-		_ = adapter.handleMatrixResult("test-query", &matrix, adapter.queryRange.End)
-		/* jsonData, err := json.Marshal(metricsMatrix) */
-		/* if err != nil { */
-		/* 	slog.Error("Failed to encode to json", "err", err, "name", metricsMatrix.Name) */
-		/* 	t.Fail() */
-		/* } */
-		/* // Write data to file */
-		/* err = os.WriteFile("query_result.json", jsonData, 0644) */
-		/* if err != nil { */
-		/* 	slog.Error("Failed to write json") */
-		/* 	t.Fail() */
-		/* } */
-	} else {
-		slog.Warn("Query did not return matrix. Skipping.")
+	for _, result := range report.Results() {
+		// results are pending until they are stored
+		assert.Equal(t, domain.QueryStatusPending, result.Status)
+		assert.Equal(t, 1, result.Attempts)
+		assert.Equal(t, len(results[result.Name].Series), result.Series)
+		assert.Equal(t, results[result.Name].Samples(), result.Samples)
+	}
+}
+
+func TestQueryInstant(t *testing.T) {
+	fixture := model.Matrix{
+		{Metric: model.Metric{"pod": "a"}, Values: samples(testEnd.Add(-time.Hour), testEnd.Add(-time.Minute), 15*time.Second, 1)},
+	}
+	api := prometheustest.NewAPI().SetFixture("up", fixture).SetGenerator(prometheustest.Synthetic(3))
+	// instant queries are never split
+	adapter := newTestAdapter(t, api, domain.Config{QueryMaxPoints: 10})
+	adapter.RegisterQuery(promql.NewQuery("up").SetName("up").SetMode(promql.ModeInstant))
+	adapter.RegisterQuery(promql.NewQuery(`scalar(sum(up))`).SetName("total").SetMode(promql.ModeInstant))
+
+	results, report := runQueries(adapter)
+	assert.Len(t, results, 2)
+	assert.Len(t, api.Requests(), 2)
+	for _, request := range api.Requests() {
+		assert.Equal(t, "/api/v1/query", request.Path)
+		assert.Equal(t, testEnd, request.End.UTC())
+	}
+
+	timestamp := model.TimeFromUnixNano(testEnd.UnixNano())
+	up := results["up"]
+	assert.Equal(t, domain.ResultTypeVector, up.ResultType)
+	assert.Equal(t, testEnd, up.Start)
+	assert.Equal(t, testEnd, up.End)
+	assert.Zero(t, up.Step)
+	assert.Equal(t, []domain.Series{
+		{Labels: model.Metric{"pod": "a"}, Values: []model.SamplePair{{Timestamp: timestamp, Value: 1}}},
+	}, up.Series)
+
+	total := results["total"]
+	assert.Equal(t, domain.ResultTypeScalar, total.ResultType)
+	assert.Len(t, total.Series, 1)
+	assert.Empty(t, total.Series[0].Labels)
+	assert.Equal(t, timestamp, total.Series[0].Values[0].Timestamp)
+
+	// the fingerprint covers the evaluation time only
+	assert.Equal(t, domain.Fingerprint("up", testEnd, testEnd, 0), up.Fingerprint)
+	assert.NotEqual(t, adapter.fingerprint(promql.NewQuery("up")), up.Fingerprint)
+	for _, result := range report.Results() {
+		assert.Equal(t, domain.QueryStatusPending, result.Status)
+		assert.Equal(t, 1, result.Attempts)
+		assert.Equal(t, 1, result.Samples)
+	}
+}
+
+func TestResultMatrix(t *testing.T) {
+	rangeQuery := promql.NewQuery("up")
+	instantQuery := promql.NewQuery("up").SetMode(promql.ModeInstant)
+	matrix := model.Matrix{{Metric: model.Metric{"pod": "a"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}}}
+	vector := model.Vector{{Metric: model.Metric{"pod": "a"}, Timestamp: 1000, Value: 1}}
+	tests := []struct {
+		name     string
+		query    *promql.Query
+		value    model.Value
+		want     model.Matrix
+		wantType domain.ResultType
+		wantErr  bool
+	}{
+		{name: "range matrix", query: rangeQuery, value: matrix, want: matrix, wantType: domain.ResultTypeMatrix},
+		{name: "range vector", query: rangeQuery, value: vector, wantErr: true},
+		{name: "instant matrix", query: instantQuery, value: matrix, want: matrix, wantType: domain.ResultTypeMatrix},
+		{name: "instant vector", query: instantQuery, value: vector, want: matrix, wantType: domain.ResultTypeVector},
+		{name: "instant scalar", query: instantQuery, value: &model.Scalar{Timestamp: 1000, Value: 1}, want: model.Matrix{
+			{Metric: model.Metric{}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}},
+		}, wantType: domain.ResultTypeScalar},
+		{name: "instant string", query: instantQuery, value: &model.String{Timestamp: 1000, Value: "a"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, resultType, err := resultMatrix(tt.query, tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantType, resultType)
+		})
 	}
 }
+
+func TestQuerySplit(t *testing.T) {
+	query := `sum by (pod) (rate(requests_total[1m]))`
+	api := prometheustest.NewAPI()
+	unsplit := newTestAdapter(t, api, domain.Config{})
+	unsplit.RegisterQuery(promql.NewQuery(query).SetName("requests"))
+	expected, _ := runQueries(unsplit)
+
+	split := newTestAdapter(t, api, domain.Config{QueryMaxPoints: 50})
+	split.RegisterQuery(promql.NewQuery(query).SetName("requests"))
+	results, report := runQueries(split)
+
+	assert.Equal(t, domain.QueryStatusPending, report.Results()[0].Status)
+	assert.Equal(t, 3, report.Results()[0].Attempts)
+	assert.Len(t, api.Requests(), 4)
+	assert.Equal(t, expected["requests"].Series, results["requests"].Series)
+}
+
+func TestQuerySplitBySamples(t *testing.T) {
+	query := `sum by (pod) (rate(requests_total[1m]))`
+	api := prometheustest.NewAPI()
+	unsplit := newTestAdapter(t, api, domain.Config{})
+	unsplit.RegisterQuery(promql.NewQuery(query).SetName("requests"))
+	expected, _ := runQueries(unsplit)
+	series := len(expected["requests"].Series)
+	assert.Greater(t, series, 1)
+
+	maxSamples := 40 * series
+	split := newTestAdapter(t, api, domain.Config{QueryMaxSamples: maxSamples})
+	split.RegisterQuery(promql.NewQuery(query).SetName("requests"))
+	results, _ := runQueries(split)
+
+	requests := api.Requests()[1:]
+	// the series count is probed with an instant query before the first chunk
+	assert.Equal(t, "/api/v1/query", requests[0].Path)
+	for _, request := range requests[1:] {
+		points := int(request.End.Sub(request.Start)/request.Step) + 1
+		assert.LessOrEqual(t, points*series, maxSamples)
+	}
+	assert.Equal(t, expected["requests"].Series, results["requests"].Series)
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        string
+		errorType    v1.ErrorType
+		failures     int
+		retries      int
+		wantStatus   domain.QueryStatus
+		wantAttempts int
+		wantError    string
+	}{
+		{name: "invalid query", query: "sum(", retries: 2, wantStatus: domain.QueryStatusQueryFailed, wantAttempts: 1, wantError: "bad_data"},
+		{name: "execution error is not retried", query: "up", errorType: v1.ErrExec, failures: 1, retries: 2, wantStatus: domain.QueryStatusQueryFailed, wantAttempts: 1, wantError: "execution"},
+		{name: "timeouts are retried", query: "up", errorType: v1.ErrTimeout, failures: 2, retries: 2, wantStatus: domain.QueryStatusPending, wantAttempts: 3},
+		{name: "retries exhausted", query: "up", errorType: v1.ErrServer, retries: 1, wantStatus: domain.QueryStatusQueryFailed, wantAttempts: 2, wantError: "server error: 500"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := prometheustest.NewAPI()
+			if tt.errorType != "" {
+				api.Fail(tt.query, tt.errorType, tt.failures)
+			}
+			adapter := newTestAdapter(t, api, domain.Config{QueryRetries: tt.retries})
+			adapter.RegisterQuery(promql.NewQuery(tt.query).SetName("a_query"))
+
+			results, report := runQueries(adapter)
+			result := report.Results()[0]
+			assert.Equal(t, tt.wantStatus, result.Status)
+			assert.Equal(t, tt.wantAttempts, result.Attempts)
+			assert.Contains(t, result.Error, tt.wantError)
+			assert.Len(t, api.Requests(), tt.wantAttempts)
+			if tt.wantStatus == domain.QueryStatusPending {
+				assert.Contains(t, results, "a_query")
+			} else {
+				assert.NotContains(t, results, "a_query")
+			}
+		})
+	}
+}
+
+func TestQueryUnreachable(t *testing.T) {
+	server := httptest.NewServer(prometheustest.NewAPI())
+	server.Close()
+	adapter, err := NewPrometheusAdapter(&domain.Config{MetricsQueryEndpoint: server.URL, EndTime: testEnd, Duration: time.Hour, Step: time.Minute})
+	assert.NoError(t, err)
+	adapter.RegisterQuery(promql.NewQuery("up").SetName("up"))
+
+	results, report := runQueries(adapter)
+	assert.Empty(t, results)
+	assert.Equal(t, 1, report.Failed())
+	assert.Contains(t, report.Results()[0].Error, "connection refused")
+}
+
+func TestHandleMatrixResult(t *testing.T) {
+	adapter := &PrometheusAdapter{queryRange: v1.Range{Start: testEnd.Add(-time.Hour), End: testEnd, Step: time.Minute}}
+	query := promql.NewQuery("up").SetName("up").SetUnit("bool")
+	matrix := model.Matrix{
+		{Metric: model.Metric{"pod": "b"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 2}}},
+		{Metric: model.Metric{"pod": "a"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}},
+	}
+
+	assert.Equal(t, &domain.MetricsMatrix{
+		Name:  "up",
+		Query: "up",
+		Start: testEnd.Add(-time.Hour),
+		End:   testEnd,
+		Step:  time.Minute,
+		Unit:  "bool",
+		Series: []domain.Series{
+			{Labels: model.Metric{"pod": "a"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}},
+			{Labels: model.Metric{"pod": "b"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 2}}},
+		},
+	}, adapter.handleMatrixResult(query, matrix))
+
+	empty := adapter.handleMatrixResult(query, model.Matrix{})
+	assert.NotNil(t, empty.Series)
+	assert.Empty(t, empty.Series)
+}
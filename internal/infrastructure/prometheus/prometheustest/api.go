@@ -0,0 +1,418 @@
+// Package prometheustest provides an in-process fake of the Prometheus HTTP API for tests and demos.
+// It serves fixture series for known queries and synthetic series for any other valid query.
+package prometheustest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// lookback is how far back an instant query looks for the latest sample of a fixture series, like in Prometheus
+const lookback = 5 * time.Minute
+
+// Version is the version reported by the buildinfo endpoint
+const Version = "fake"
+
+// Fixture is the result of a query, in the format of the result of a range query of the Prometheus HTTP API
+type Fixture struct {
+	Query  string       `json:"query"`
+	Result model.Matrix `json:"result"`
+}
+
+// Request records a query received by the API
+type Request struct {
+	Path  string
+	Query string
+	Start time.Time
+	End   time.Time
+	Step  time.Duration
+}
+
+// failure makes the API answer a query with an error
+type failure struct {
+	errorType v1.ErrorType
+	remaining int
+}
+
+// API is a fake of the Prometheus HTTP API.
+// Queries with a fixture return the samples of the fixture in the queried range, other queries return synthetic series.
+type API struct {
+	mu        sync.Mutex
+	mux       *http.ServeMux
+	fixtures  map[string]model.Matrix
+	generator Generator
+	failures  map[string]*failure
+	requests  []Request
+}
+
+// NewAPI creates an API without fixtures that generates synthetic series with Synthetic(3)
+func NewAPI() *API {
+	api := &API{
+		mux:       http.NewServeMux(),
+		fixtures:  map[string]model.Matrix{},
+		generator: Synthetic(3),
+		failures:  map[string]*failure{},
+	}
+	api.mux.HandleFunc("/api/v1/query_range", api.queryRange)
+	api.mux.HandleFunc("/api/v1/query", api.query)
+	api.mux.HandleFunc("/api/v1/series", api.series)
+	api.mux.HandleFunc("/api/v1/labels", api.labels)
+	api.mux.HandleFunc("/api/v1/status/buildinfo", api.buildinfo)
+	return api
+}
+
+// SetFixture serves matrix as the result of query
+func (a *API) SetFixture(query string, matrix model.Matrix) *API {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fixtures[query] = matrix
+	return a
+}
+
+// LoadFixtures serves the fixtures of the json file at path, a list of Fixture
+func (a *API) LoadFixtures(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read fixtures %q, %w", path, err)
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return fmt.Errorf("Failed to unmarshal fixtures %q, %w", path, err)
+	}
+	for _, fixture := range fixtures {
+		a.SetFixture(fixture.Query, fixture.Result)
+	}
+	return nil
+}
+
+// SetGenerator generates the series of queries without a fixture with generator.
+// A nil generator returns no series.
+func (a *API) SetGenerator(generator Generator) *API {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.generator = generator
+	return a
+}
+
+// Fail answers the next times requests for query with an error of errorType and the status code Prometheus uses for it.
+// Every request fails when times is 0 or less.
+func (a *API) Fail(query string, errorType v1.ErrorType, times int) *API {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failures[query] = &failure{errorType: errorType, remaining: times}
+	return a
+}
+
+// Requests returns the queries received so far, in order
+func (a *API) Requests() []Request {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.requests)
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) queryRange(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, v1.ErrBadData, err)
+		return
+	}
+	query := r.Form.Get("query")
+	start, err := parseTime(r.Form.Get("start"))
+	if err != nil {
+		writeError(w, v1.ErrBadData, fmt.Errorf("invalid parameter \"start\": %w", err))
+		return
+	}
+	end, err := parseTime(r.Form.Get("end"))
+	if err != nil {
+		writeError(w, v1.ErrBadData, fmt.Errorf("invalid parameter \"end\": %w", err))
+		return
+	}
+	step, err := parseDuration(r.Form.Get("step"))
+	if err != nil {
+		writeError(w, v1.ErrBadData, fmt.Errorf("invalid parameter \"step\": %w", err))
+		return
+	}
+	if end.Before(start) || step <= 0 {
+		writeError(w, v1.ErrBadData, fmt.Errorf("invalid range %s - %s with step %s", start, end, step))
+		return
+	}
+	if errorType, ok := a.record(Request{Path: r.URL.Path, Query: query, Start: start, End: end, Step: step}); !ok {
+		writeError(w, errorType, fmt.Errorf("injected %s error", errorType))
+		return
+	}
+
+	matrix, err := a.evaluate(query, timestamps(start, end, step), 0)
+	if err != nil {
+		writeError(w, v1.ErrBadData, err)
+		return
+	}
+	writeData(w, map[string]any{"resultType": model.ValMatrix.String(), "result": matrix})
+}
+
+func (a *API) query(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, v1.ErrBadData, err)
+		return
+	}
+	query := r.Form.Get("query")
+	at := time.Now()
+	if param := r.Form.Get("time"); param != "" {
+		var err error
+		if at, err = parseTime(param); err != nil {
+			writeError(w, v1.ErrBadData, fmt.Errorf("invalid parameter \"time\": %w", err))
+			return
+		}
+	}
+	if errorType, ok := a.record(Request{Path: r.URL.Path, Query: query, Start: at, End: at}); !ok {
+		writeError(w, errorType, fmt.Errorf("injected %s error", errorType))
+		return
+	}
+
+	matrix, err := a.evaluate(query, []model.Time{model.TimeFromUnixNano(at.UnixNano())}, lookback)
+	if err != nil {
+		writeError(w, v1.ErrBadData, err)
+		return
+	}
+	vector := latest(matrix, at)
+	if a.scalar(query) && len(vector) == 1 {
+		writeData(w, map[string]any{"resultType": model.ValScalar.String(), "result": &model.Scalar{Value: vector[0].Value, Timestamp: vector[0].Timestamp}})
+		return
+	}
+	writeData(w, map[string]any{"resultType": model.ValVector.String(), "result": vector})
+}
+
+// scalar reports whether query is a scalar expression without a fixture, which an instant query returns as a scalar
+func (a *API) scalar(query string) bool {
+	a.mu.Lock()
+	_, ok := a.fixtures[query]
+	a.mu.Unlock()
+	if ok {
+		return false
+	}
+	expr, err := parser.ParseExpr(query)
+	return err == nil && expr.Type() == parser.ValueTypeScalar
+}
+
+func (a *API) series(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, v1.ErrBadData, err)
+		return
+	}
+	var matcherSets [][]*labels.Matcher
+	for _, match := range r.Form["match[]"] {
+		matchers, err := parser.ParseMetricSelector(match)
+		if err != nil {
+			writeError(w, v1.ErrBadData, err)
+			return
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+	if len(matcherSets) == 0 {
+		writeError(w, v1.ErrBadData, fmt.Errorf("no match[] parameter provided"))
+		return
+	}
+
+	seen := map[model.Fingerprint]bool{}
+	result := []model.LabelSet{}
+	for _, metric := range a.knownSeries() {
+		for _, matchers := range matcherSets {
+			if !seen[metric.Fingerprint()] && matches(metric, matchers) {
+				seen[metric.Fingerprint()] = true
+				result = append(result, model.LabelSet(metric))
+			}
+		}
+	}
+	writeData(w, result)
+}
+
+func (a *API) labels(w http.ResponseWriter, r *http.Request) {
+	names := []string{model.MetricNameLabel}
+	for _, metric := range a.knownSeries() {
+		for name := range metric {
+			if !slices.Contains(names, string(name)) {
+				names = append(names, string(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	writeData(w, names)
+}
+
+func (a *API) buildinfo(w http.ResponseWriter, r *http.Request) {
+	writeData(w, v1.BuildinfoResult{Version: Version, Branch: "main", GoVersion: "go"})
+}
+
+// record appends the request to the log and reports whether it should succeed, or the error type to fail it with
+func (a *API) record(request Request) (v1.ErrorType, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests = append(a.requests, request)
+	failure, ok := a.failures[request.Query]
+	if !ok {
+		return "", true
+	}
+	if failure.remaining > 0 {
+		failure.remaining--
+		if failure.remaining == 0 {
+			delete(a.failures, request.Query)
+		}
+	}
+	return failure.errorType, false
+}
+
+// evaluate returns the series of query at the timestamps, from its fixture or the generator.
+// Fixture samples up to lookback before the first timestamp are included.
+func (a *API) evaluate(query string, timestamps []model.Time, lookback time.Duration) (model.Matrix, error) {
+	a.mu.Lock()
+	fixture, ok := a.fixtures[query]
+	generator := a.generator
+	a.mu.Unlock()
+
+	if ok {
+		return window(fixture, timestamps[0].Add(-lookback), timestamps[len(timestamps)-1]), nil
+	}
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	if generator == nil {
+		return model.Matrix{}, nil
+	}
+	return generator(expr, timestamps), nil
+}
+
+// knownSeries returns the label sets of the fixture series
+func (a *API) knownSeries() []model.Metric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var metrics []model.Metric
+	for _, matrix := range a.fixtures {
+		for _, sampleStream := range matrix {
+			metrics = append(metrics, sampleStream.Metric)
+		}
+	}
+	slices.SortFunc(metrics, func(a, b model.Metric) int {
+		if a.Before(b) {
+			return -1
+		}
+		if b.Before(a) {
+			return 1
+		}
+		return 0
+	})
+	return metrics
+}
+
+// window returns the samples of matrix between start and end, inclusive
+func window(matrix model.Matrix, start, end model.Time) model.Matrix {
+	result := model.Matrix{}
+	for _, sampleStream := range matrix {
+		var values []model.SamplePair
+		for _, sample := range sampleStream.Values {
+			if !sample.Timestamp.Before(start) && !sample.Timestamp.After(end) {
+				values = append(values, sample)
+			}
+		}
+		if len(values) > 0 {
+			result = append(result, &model.SampleStream{Metric: sampleStream.Metric, Values: values})
+		}
+	}
+	return result
+}
+
+// latest returns the latest sample of each series at or before at, within the lookback
+func latest(matrix model.Matrix, at time.Time) model.Vector {
+	timestamp := model.TimeFromUnixNano(at.UnixNano())
+	vector := model.Vector{}
+	for _, sampleStream := range matrix {
+		for i := len(sampleStream.Values) - 1; i >= 0; i-- {
+			sample := sampleStream.Values[i]
+			if sample.Timestamp.After(timestamp) {
+				continue
+			}
+			if timestamp.Sub(sample.Timestamp) <= lookback {
+				vector = append(vector, &model.Sample{Metric: sampleStream.Metric, Value: sample.Value, Timestamp: timestamp})
+			}
+			break
+		}
+	}
+	return vector
+}
+
+// timestamps returns the evaluation timestamps of a range query
+func timestamps(start, end time.Time, step time.Duration) []model.Time {
+	var result []model.Time
+	for t := start; !t.After(end); t = t.Add(step) {
+		result = append(result, model.TimeFromUnixNano(t.UnixNano()))
+	}
+	return result
+}
+
+func matches(metric model.Metric, matchers []*labels.Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(string(metric[model.LabelName(matcher.Name)])) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTime parses a time parameter given in unix seconds or RFC 3339
+func parseTime(param string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(param, 64); err == nil {
+		whole, fraction := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(math.Round(fraction*1e9))).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, param)
+}
+
+// parseDuration parses a duration parameter given in seconds or as a Prometheus duration
+func parseDuration(param string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(param, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	duration, err := model.ParseDuration(param)
+	return time.Duration(duration), err
+}
+
+// statusCodes maps error types to the status codes Prometheus answers them with
+var statusCodes = map[v1.ErrorType]int{
+	v1.ErrBadData:  http.StatusBadRequest,
+	v1.ErrExec:     http.StatusUnprocessableEntity,
+	v1.ErrCanceled: 499,
+	v1.ErrTimeout:  http.StatusServiceUnavailable,
+	v1.ErrServer:   http.StatusInternalServerError,
+}
+
+func writeError(w http.ResponseWriter, errorType v1.ErrorType, err error) {
+	code, ok := statusCodes[errorType]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	writeJSON(w, code, map[string]any{"status": "error", "errorType": errorType, "error": err.Error()})
+}
+
+func writeData(w http.ResponseWriter, data any) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "success", "data": data})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
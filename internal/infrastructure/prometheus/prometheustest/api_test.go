@@ -0,0 +1,127 @@
+package prometheustest
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func newClient(t *testing.T, fake *API) v1.API {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client, err := api.NewClient(api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v1.NewAPI(client)
+}
+
+func TestFixtures(t *testing.T) {
+	fake := NewAPI()
+	assert.NoError(t, fake.LoadFixtures("testdata/fixtures.json"))
+	client := newClient(t, fake)
+	ctx := context.Background()
+	end := time.Unix(1609459200, 0)
+
+	result, _, err := client.QueryRange(ctx, "up", v1.Range{Start: end.Add(-30 * time.Second), End: end, Step: 30 * time.Second})
+	assert.NoError(t, err)
+	matrix := result.(model.Matrix)
+	assert.Len(t, matrix, 2)
+	assert.Equal(t, []model.SamplePair{{Timestamp: 1609459200000, Value: 1}}, matrix[0].Values)
+
+	result, _, err = client.Query(ctx, "up", end.Add(time.Minute))
+	assert.NoError(t, err)
+	vector := result.(model.Vector)
+	assert.Len(t, vector, 2)
+	assert.Equal(t, model.SampleValue(0), vector[1].Value)
+	assert.Equal(t, model.TimeFromUnix(end.Add(time.Minute).Unix()), vector[1].Timestamp)
+
+	// samples older than the lookback are not returned by instant queries
+	result, _, err = client.Query(ctx, "up", end.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, result.(model.Vector))
+
+	series, _, err := client.Series(ctx, []string{`up{job="node"}`}, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, []model.LabelSet{{"__name__": "up", "job": "node", "instance": "localhost:9100"}}, series)
+
+	names, _, err := client.LabelNames(ctx, nil, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"__name__", "instance", "job"}, names)
+
+	buildinfo, err := client.Buildinfo(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Version, buildinfo.Version)
+
+	assert.Equal(t, []string{"up", "up", "up"}, []string{fake.Requests()[0].Query, fake.Requests()[1].Query, fake.Requests()[2].Query})
+	assert.Equal(t, 30*time.Second, fake.Requests()[0].Step)
+}
+
+func TestFail(t *testing.T) {
+	fake := NewAPI().Fail("up", v1.ErrTimeout, 1)
+	client := newClient(t, fake)
+
+	_, _, err := client.Query(context.Background(), "up", time.Now())
+	assert.ErrorContains(t, err, "server error: 503")
+	_, _, err = client.Query(context.Background(), "up", time.Now())
+	assert.NoError(t, err)
+
+	_, _, err = client.Query(context.Background(), "sum(", time.Now())
+	var apiErr *v1.Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, v1.ErrBadData, apiErr.Type)
+}
+
+func TestSynthetic(t *testing.T) {
+	timestamps := []model.Time{0, 15000, 30000}
+	tests := []struct {
+		query  string
+		labels []model.Metric
+	}{
+		{query: `sum by (deployment, status) (rate(requests_total[1m]))`, labels: []model.Metric{
+			{"deployment": "deployment-0", "status": "status-0"},
+			{"deployment": "deployment-1", "status": "status-1"},
+		}},
+		{query: `sum(rate(requests_total[1m])) / sum by (pod) (up)`, labels: []model.Metric{{}}},
+		{query: `sum without (pod) (up)`, labels: []model.Metric{{"instance": "instance-0"}, {"instance": "instance-1"}}},
+		{query: `up`, labels: []model.Metric{{"instance": "instance-0"}, {"instance": "instance-1"}}},
+		{query: `scalar(up) * 2`, labels: []model.Metric{{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := parser.ParseExpr(tt.query)
+			assert.NoError(t, err)
+			matrix := Synthetic(2)(expr, timestamps)
+			var labels []model.Metric
+			for _, sampleStream := range matrix {
+				labels = append(labels, sampleStream.Metric)
+				assert.Len(t, sampleStream.Values, len(timestamps))
+			}
+			assert.Equal(t, tt.labels, labels)
+			// series are deterministic
+			assert.Equal(t, matrix, Synthetic(2)(expr, timestamps))
+		})
+	}
+}
+
+func TestInstantScalar(t *testing.T) {
+	client := newClient(t, NewAPI().SetGenerator(Synthetic(2)))
+	end := time.Unix(1609459200, 0)
+
+	result, _, err := client.Query(context.Background(), `scalar(sum(up))`, end)
+	assert.NoError(t, err)
+	scalar, ok := result.(*model.Scalar)
+	assert.True(t, ok, "expected scalar, got %s", result.Type())
+	assert.Equal(t, model.TimeFromUnix(end.Unix()), scalar.Timestamp)
+
+	result, _, err = client.Query(context.Background(), `sum by (pod) (up)`, end)
+	assert.NoError(t, err)
+	assert.Len(t, result.(model.Vector), 2)
+}
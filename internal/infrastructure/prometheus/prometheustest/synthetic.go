@@ -0,0 +1,73 @@
+package prometheustest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Generator returns the series of the parsed query evaluated at the timestamps
+type Generator func(expr parser.Expr, timestamps []model.Time) model.Matrix
+
+// Synthetic generates deterministic series shaped like the result of the query.
+// An aggregation by labels returns series with values of those labels, an aggregation without grouping or
+// a scalar expression a single series without labels and any other query series with values of the instance label.
+// Values are a smooth wave around a level derived from the query text, so results are stable across runs.
+func Synthetic(series int) Generator {
+	return func(expr parser.Expr, timestamps []model.Time) model.Matrix {
+		metrics := syntheticMetrics(expr, series)
+		seed := hash(expr.String())
+		matrix := make(model.Matrix, 0, len(metrics))
+		for i, metric := range metrics {
+			level := float64(seed%100) + float64(i)
+			values := make([]model.SamplePair, 0, len(timestamps))
+			for _, timestamp := range timestamps {
+				phase := float64(timestamp.Unix())/300 + float64(i)
+				values = append(values, model.SamplePair{Timestamp: timestamp, Value: model.SampleValue(level + math.Sin(phase))})
+			}
+			matrix = append(matrix, &model.SampleStream{Metric: metric, Values: values})
+		}
+		return matrix
+	}
+}
+
+// syntheticMetrics returns the label sets of the series of the query, based on its outermost aggregation
+func syntheticMetrics(expr parser.Expr, series int) []model.Metric {
+	var grouping []string
+	aggregated, single := false, false
+	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
+		if aggregate, ok := node.(*parser.AggregateExpr); ok && !aggregated {
+			aggregated = true
+			if !aggregate.Without {
+				grouping = aggregate.Grouping
+				single = len(grouping) == 0
+			}
+		}
+		return nil
+	})
+	if single || expr.Type() == parser.ValueTypeScalar {
+		return []model.Metric{{}}
+	}
+	if len(grouping) == 0 {
+		grouping = []string{"instance"}
+	}
+
+	metrics := make([]model.Metric, 0, series)
+	for i := range series {
+		metric := model.Metric{}
+		for _, name := range grouping {
+			metric[model.LabelName(name)] = model.LabelValue(fmt.Sprintf("%s-%d", name, i))
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+func hash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
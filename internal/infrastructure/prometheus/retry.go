@@ -0,0 +1,57 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// RetryConfig configures timeout and retries of each query
+type RetryConfig struct {
+	Timeout time.Duration
+	// Retries is the number of attempts made after the first one
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// isRetryable reports whether a failed query is worth retrying.
+// Only timeouts, 5xx responses and 429 responses are retried.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Type {
+		case v1.ErrTimeout, v1.ErrServer:
+			return true
+		case v1.ErrClient:
+			return apiErr.Msg == "client error: 429"
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// backoff returns the wait before the next attempt using exponential backoff with full jitter
+func (rc RetryConfig) backoff(attempt int) time.Duration {
+	if rc.Backoff <= 0 {
+		return 0
+	}
+	ceiling := rc.Backoff << attempt
+	if ceiling <= 0 || (rc.MaxBackoff > 0 && ceiling > rc.MaxBackoff) {
+		ceiling = rc.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
@@ -0,0 +1,49 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "client deadline", err: fmt.Errorf("post: %w", context.DeadlineExceeded), expected: true},
+		{name: "prometheus timeout", err: &v1.Error{Type: v1.ErrTimeout, Msg: "query timed out"}, expected: true},
+		{name: "server error", err: &v1.Error{Type: v1.ErrServer, Msg: "server error: 503"}, expected: true},
+		{name: "too many requests", err: &v1.Error{Type: v1.ErrClient, Msg: "client error: 429"}, expected: true},
+		{name: "other client error", err: &v1.Error{Type: v1.ErrClient, Msg: "client error: 404"}, expected: false},
+		{name: "bad query", err: &v1.Error{Type: v1.ErrBadData, Msg: "parse error"}, expected: false},
+		{name: "canceled", err: context.Canceled, expected: false},
+		{name: "other", err: errors.New("connection refused"), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, isRetryable(tt.err))
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	rc := RetryConfig{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for attempt := 0; attempt < 10; attempt++ {
+		ceiling := min(time.Second<<attempt, 5*time.Second)
+		for i := 0; i < 20; i++ {
+			wait := rc.backoff(attempt)
+			assert.GreaterOrEqual(t, wait, time.Duration(0))
+			assert.LessOrEqual(t, wait, ceiling)
+		}
+	}
+
+	assert.Equal(t, time.Duration(0), RetryConfig{}.backoff(3))
+}
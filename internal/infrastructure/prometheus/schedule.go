@@ -0,0 +1,62 @@
+package prometheus
+
+import (
+	"slices"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/pkg/promql"
+)
+
+// ScheduleConfig configures how registered queries are dispatched
+type ScheduleConfig struct {
+	// Parallelism is the number of queries run at once. Unbounded when 0 or less.
+	Parallelism int
+	// RateLimit is the number of queries started per second. Unlimited when 0 or less.
+	RateLimit float64
+}
+
+// scheduledQueries returns the registered queries in dispatch order.
+// Higher priority first, then cheaper queries first so that gauges are not starved behind histograms.
+func (pa *PrometheusAdapter) scheduledQueries() []*promql.Query {
+	queries := slices.Clone(pa.queries)
+	slices.SortStableFunc(queries, func(a, b *promql.Query) int {
+		if a.Priority() != b.Priority() {
+			return b.Priority() - a.Priority()
+		}
+		return a.Cost() - b.Cost()
+	})
+	return queries
+}
+
+func (sc ScheduleConfig) workers(queries int) int {
+	if sc.Parallelism <= 0 || sc.Parallelism > queries {
+		return queries
+	}
+	return sc.Parallelism
+}
+
+// rateLimiter spaces calls to Wait so that at most the configured number return per second
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return &rateLimiter{}
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// Wait blocks until the next call is allowed. It is not safe for concurrent use.
+func (rl *rateLimiter) Wait() {
+	if rl.interval == 0 {
+		return
+	}
+	now := time.Now()
+	if rl.next.After(now) {
+		time.Sleep(rl.next.Sub(now))
+		now = rl.next
+	}
+	rl.next = now.Add(rl.interval)
+}
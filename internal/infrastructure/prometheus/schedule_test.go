@@ -0,0 +1,47 @@
+package prometheus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/pkg/promql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduledQueries(t *testing.T) {
+	adapter := &PrometheusAdapter{}
+	adapter.RegisterQuery(promql.NewQuery("a_bucket").Rate(time.Minute).SumBy([]string{"le"}).HistogramQuantile(0.99).SetName("histogram"))
+	adapter.RegisterQuery(promql.NewQuery("a_count").Rate(time.Minute).SetName("counter"))
+	adapter.RegisterQuery(promql.NewQuery("a").SetName("gauge"))
+	adapter.RegisterQuery(promql.NewQuery("b").SetName("gauge_2"))
+	adapter.RegisterQuery(promql.NewQuery("b_bucket").SetName("important").SetPriority(1))
+
+	var names []string
+	for _, query := range adapter.scheduledQueries() {
+		names = append(names, query.Name)
+	}
+	assert.Equal(t, []string{"important", "gauge", "gauge_2", "counter", "histogram"}, names)
+}
+
+func TestScheduleWorkers(t *testing.T) {
+	assert.Equal(t, 10, ScheduleConfig{}.workers(10))
+	assert.Equal(t, 4, ScheduleConfig{Parallelism: 4}.workers(10))
+	assert.Equal(t, 3, ScheduleConfig{Parallelism: 4}.workers(3))
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(100)
+	start := time.Now()
+	for range 5 {
+		limiter.Wait()
+	}
+	// the first call is not delayed, the remaining four are spaced 10ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	unlimited := newRateLimiter(0)
+	start = time.Now()
+	for range 100 {
+		unlimited.Wait()
+	}
+	assert.Less(t, time.Since(start), 10*time.Millisecond)
+}
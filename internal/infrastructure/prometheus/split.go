@@ -0,0 +1,84 @@
+package prometheus
+
+import (
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// DefaultMaxPoints is the maximum number of points per series Prometheus returns for a range query
+const DefaultMaxPoints = 11000
+
+// SplitConfig configures how long ranges are split into several range queries
+type SplitConfig struct {
+	// MaxPoints is the maximum number of points per series in one request
+	MaxPoints int
+	// MaxSamples is the maximum number of samples (series x points) in one request. Disabled when 0.
+	// The number of series is probed with an instant query at the start of the range
+	// and raised to the number of series seen in previous chunks.
+	MaxSamples int
+}
+
+// chunkPoints returns the number of points for the next chunk given the number of series seen so far
+func (sc SplitConfig) chunkPoints(series int) int {
+	points := sc.MaxPoints
+	if points <= 0 {
+		points = DefaultMaxPoints
+	}
+	if sc.MaxSamples > 0 {
+		points = min(points, sc.MaxSamples/max(series, 1))
+	}
+	return max(points, 1)
+}
+
+// nextChunk returns the chunk of at most points points starting at start.
+// Chunks are aligned to the step of queryRange so consecutive chunks never share a timestamp.
+func nextChunk(queryRange v1.Range, start time.Time, points int) v1.Range {
+	end := start.Add(time.Duration(points-1) * queryRange.Step)
+	if end.After(queryRange.End) {
+		end = queryRange.End
+	}
+	return v1.Range{Start: start, End: end, Step: queryRange.Step}
+}
+
+// stitcher merges the matrices of consecutive chunks into one matrix
+type stitcher struct {
+	streams []*model.SampleStream
+	index   map[model.Fingerprint]*model.SampleStream
+}
+
+func newStitcher() *stitcher {
+	return &stitcher{index: make(map[model.Fingerprint]*model.SampleStream)}
+}
+
+// add appends the samples of a chunk, dropping samples not after the last sample of the series
+func (s *stitcher) add(matrix model.Matrix) {
+	for _, sampleStream := range matrix {
+		fingerprint := sampleStream.Metric.Fingerprint()
+		stream, ok := s.index[fingerprint]
+		if !ok {
+			stream = &model.SampleStream{Metric: sampleStream.Metric}
+			s.index[fingerprint] = stream
+			s.streams = append(s.streams, stream)
+		}
+		for _, sample := range sampleStream.Values {
+			if n := len(stream.Values); n > 0 && !sample.Timestamp.After(stream.Values[n-1].Timestamp) {
+				continue
+			}
+			stream.Values = append(stream.Values, sample)
+		}
+	}
+}
+
+func (s *stitcher) series() int {
+	return len(s.streams)
+}
+
+func (s *stitcher) matrix() model.Matrix {
+	matrix := make(model.Matrix, 0, len(s.streams))
+	for _, stream := range s.streams {
+		matrix = append(matrix, stream)
+	}
+	return matrix
+}
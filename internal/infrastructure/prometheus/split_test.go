@@ -0,0 +1,104 @@
+package prometheus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/pkg/promql"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// rangeAPI answers range queries with one sample per step for each series and records the requested ranges
+type rangeAPI struct {
+	v1.API
+	series int
+	ranges []v1.Range
+	probes int
+}
+
+func (ra *rangeAPI) QueryRange(ctx context.Context, query string, r v1.Range, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	ra.ranges = append(ra.ranges, r)
+	matrix := model.Matrix{}
+	for i := range ra.series {
+		stream := &model.SampleStream{Metric: model.Metric{"series": model.LabelValue(rune('a' + i))}}
+		// include the sample before the chunk to check that boundary duplicates are dropped
+		for ts := r.Start.Add(-r.Step); !ts.After(r.End); ts = ts.Add(r.Step) {
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: 1})
+		}
+		matrix = append(matrix, stream)
+	}
+	return matrix, nil, nil
+}
+
+// Query answers the series count probe with one sample for each series
+func (ra *rangeAPI) Query(ctx context.Context, query string, ts time.Time, opts ...v1.Option) (model.Value, v1.Warnings, error) {
+	ra.probes++
+	vector := model.Vector{}
+	for i := range ra.series {
+		vector = append(vector, &model.Sample{Metric: model.Metric{"series": model.LabelValue(rune('a' + i))}, Timestamp: model.TimeFromUnixNano(ts.UnixNano()), Value: 1})
+	}
+	return vector, nil, nil
+}
+
+func TestQueryRangeSplit(t *testing.T) {
+	start := time.Unix(1609459200, 0)
+	queryRange := v1.Range{Start: start, End: start.Add(100 * time.Second), Step: time.Second}
+
+	tests := []struct {
+		name   string
+		split  SplitConfig
+		series int
+		chunks int
+		probes int
+	}{
+		{name: "fits in one request", split: SplitConfig{MaxPoints: 11000}, series: 2, chunks: 1},
+		{name: "split by points", split: SplitConfig{MaxPoints: 30}, series: 2, chunks: 4},
+		{name: "split by samples", split: SplitConfig{MaxPoints: 11000, MaxSamples: 60}, series: 2, chunks: 4, probes: 1},
+		{name: "fits in one request by samples", split: SplitConfig{MaxPoints: 11000, MaxSamples: 1000}, series: 2, chunks: 1, probes: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &rangeAPI{series: tt.series}
+			adapter := &PrometheusAdapter{client: api, queryRange: queryRange, split: tt.split}
+			queryResult := domain.QueryResult{}
+
+			result, _, err := adapter.queryRangeSplit(promql.NewQuery("up"), &queryResult)
+			assert.NoError(t, err)
+			assert.Len(t, api.ranges, tt.chunks)
+			assert.Equal(t, tt.probes, api.probes)
+			assert.Equal(t, tt.chunks, queryResult.Attempts)
+			if tt.split.MaxSamples > 0 {
+				for _, r := range api.ranges {
+					points := int(r.End.Sub(r.Start)/r.Step) + 1
+					assert.LessOrEqual(t, points*tt.series, tt.split.MaxSamples)
+				}
+			}
+
+			matrix := result.(model.Matrix)
+			assert.Len(t, matrix, tt.series)
+			for _, stream := range matrix {
+				if len(api.ranges) == 1 {
+					// a single request is returned as is
+					continue
+				}
+				assert.Len(t, stream.Values, 102)
+				for i := 1; i < len(stream.Values); i++ {
+					assert.Equal(t, time.Second, stream.Values[i].Timestamp.Sub(stream.Values[i-1].Timestamp))
+				}
+			}
+			assert.Equal(t, queryRange.End, api.ranges[len(api.ranges)-1].End)
+		})
+	}
+}
+
+func TestChunkPoints(t *testing.T) {
+	assert.Equal(t, DefaultMaxPoints, SplitConfig{}.chunkPoints(10))
+	assert.Equal(t, 100, SplitConfig{MaxPoints: 100}.chunkPoints(10))
+	assert.Equal(t, 50, SplitConfig{MaxPoints: 100, MaxSamples: 500}.chunkPoints(10))
+	assert.Equal(t, 1, SplitConfig{MaxPoints: 100, MaxSamples: 5}.chunkPoints(10))
+}
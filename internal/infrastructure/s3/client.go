@@ -2,105 +2,346 @@ package s3
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/format"
 )
 
 type S3Adapter struct {
 	client       *s3.S3
+	uploader     *s3manager.Uploader
 	bucketName   string
 	keyParentDir string
+	encoder      *format.Encoder
 }
 
 func NewS3Adapter(config *domain.Config) (*S3Adapter, error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(config.AWSRegion)},
-	)
+	sess, err := session.NewSessionWithOptions(sessionOptions(config))
 	if err != nil {
 		return nil, err
 	}
+	client := s3.New(sess)
 	return &S3Adapter{
-		client:       s3.New(sess),
+		client: client,
+		// Upload one part at a time so that every upload buffers a single part
+		uploader: s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+			u.PartSize = s3manager.MinUploadPartSize
+			u.Concurrency = 1
+		}),
 		bucketName:   config.S3Bucket,
 		keyParentDir: config.S3BucketDir,
+		encoder:      format.NewEncoder(config),
 	}, nil
 }
 
-func (sa *S3Adapter) Save(metricsChan <-chan *domain.MetricsMatrix) {
+func (sa *S3Adapter) Save(metricsChan <-chan *domain.MetricsMatrix, report *domain.RunReport) {
 	for metricsMatrix := range metricsChan {
-		// Serialize the struct to JSON
-		jsonData, err := json.Marshal(metricsMatrix)
+		key := getResultKey(sa.keyParentDir, metricsMatrix.Name, sa.encoder.Extension())
+		object, err := sa.upload(key, metricsMatrix)
 		if err != nil {
-			slog.Error("Failed to encode to json", "err", err, "name", metricsMatrix.Name)
+			slog.Error("Failed to upload to s3", "err", err, "bucketName", sa.bucketName, "key", key)
+			report.RecordSave(metricsMatrix.Name, domain.SavedObject{Key: key}, err)
+			continue
 		}
+		report.RecordSave(metricsMatrix.Name, object, nil)
+	}
+}
 
-		key := getS3Key(sa.keyParentDir, metricsMatrix.Name)
-		// Upload JSON data to S3
-		_, err = sa.client.PutObject(&s3.PutObjectInput{
-			Bucket:        aws.String(sa.bucketName),
-			Key:           aws.String(key),
-			Body:          bytes.NewReader(jsonData),
-			ContentLength: aws.Int64(int64(len(jsonData))),
-			ContentType:   aws.String("application/json"),
-		})
-		if err != nil {
-			slog.Error("Failed to upload to s3", "err", err, "bucketName", sa.bucketName, "key", key)
+// upload streams the encoded matrix to S3.
+// Results larger than a part are sent as a multipart upload, so the encoded result is never held in memory as a whole.
+func (sa *S3Adapter) upload(key string, metricsMatrix *domain.MetricsMatrix) (domain.SavedObject, error) {
+	reader, writer := io.Pipe()
+	checksum := domain.NewChecksumWriter()
+	encoded := make(chan struct{})
+	go func() {
+		defer close(encoded)
+		writer.CloseWithError(sa.encoder.Encode(io.MultiWriter(writer, checksum), metricsMatrix))
+	}()
+
+	input := &s3manager.UploadInput{
+		Bucket:      aws.String(sa.bucketName),
+		Key:         aws.String(key),
+		Body:        reader,
+		ContentType: aws.String(sa.encoder.ContentType()),
+	}
+	if encoding := sa.encoder.ContentEncoding(); encoding != "" {
+		input.ContentEncoding = aws.String(encoding)
+	}
+	_, err := sa.uploader.Upload(input)
+	// Unblock the encoder when the upload stopped reading early
+	reader.CloseWithError(err)
+	<-encoded
+	if err != nil {
+		return domain.SavedObject{Key: key}, err
+	}
+	return domain.SavedObject{
+		Key:      key,
+		Bytes:    checksum.Bytes(),
+		Checksum: checksum.Checksum(),
+	}, nil
+}
+
+func (sa *S3Adapter) WriteManifest(manifest *domain.Manifest) error {
+	jsonData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to encode manifest, %w", err)
+	}
+	key := getS3Key(sa.keyParentDir, domain.ManifestName)
+	if err := sa.putObject(key, jsonData); err != nil {
+		return fmt.Errorf("Unable to upload manifest %q, %w", key, err)
+	}
+	slog.Info("Manifest saved", "bucket", sa.bucketName, "key", key)
+	return nil
+}
+
+func (sa *S3Adapter) ReadManifest() (*domain.Manifest, error) {
+	key := getS3Key(sa.keyParentDir, domain.ManifestName)
+	body, err := sa.getObject(key)
+	if err != nil {
+		return nil, err
+	}
+	var manifest domain.Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal manifest, %w", err)
+	}
+	return &manifest, nil
+}
+
+func (sa *S3Adapter) ReadStored(names []string) ([]domain.ManifestQuery, error) {
+	keys := map[string]bool{}
+	err := sa.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(sa.bucketName),
+		Prefix:    aws.String(sa.keyParentDir + "/"),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys[*object.Key] = true
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %w", sa.bucketName, err)
+	}
+
+	var stored []domain.ManifestQuery
+	for _, name := range names {
+		for _, extension := range sa.encoder.Extensions() {
+			key := getResultKey(sa.keyParentDir, name, extension)
+			if !keys[key] {
+				continue
+			}
+			body, err := sa.getObject(key)
+			if err != nil {
+				return nil, err
+			}
+			metricsMatrix, err := format.Decode(key, body)
+			if err != nil {
+				return nil, err
+			}
+			stored = append(stored, domain.StoredQuery(key, body, metricsMatrix))
+			break
 		}
 	}
+	return stored, nil
 }
 
-func (sa *S3Adapter) ParseEndTime() (float64, error) {
-	// List the first file in the bucket with the prefix
+func (sa *S3Adapter) ParseEndTime() (time.Time, error) {
+	// List the first files in the bucket with the prefix
 	resp, err := sa.client.ListObjectsV2(&s3.ListObjectsV2Input{
 		Bucket: aws.String(sa.bucketName),
 		Prefix: aws.String(sa.keyParentDir),
-		MaxKeys: aws.Int64(1), // To get the first file
+		MaxKeys: aws.Int64(2), // To get the first file other than the manifest
 	})
 	if err != nil {
-		return 0, fmt.Errorf("Unable to list items in bucket %q, %w", sa.bucketName, err)
+		return time.Time{}, fmt.Errorf("Unable to list items in bucket %q, %w", sa.bucketName, err)
 	}
 
-	// Ensure at least one file is returned
-	if len(resp.Contents) == 0 {
-		return 0, fmt.Errorf("No files found with prefix %s", sa.keyParentDir)
+	// Get the first file's key, skipping the manifest
+	manifestKey := getS3Key(sa.keyParentDir, domain.ManifestName)
+	var fileKey string
+	for _, object := range resp.Contents {
+		if *object.Key != manifestKey && format.IsResult(*object.Key) {
+			fileKey = *object.Key
+			break
+		}
 	}
 
-	// Get the first file's key
-	fileKey := *resp.Contents[0].Key
+	// Ensure at least one file is returned
+	if fileKey == "" {
+		return time.Time{}, fmt.Errorf("No files found with prefix %s", sa.keyParentDir)
+	}
 	slog.Info("Found file", "file", fileKey)
 
 	// Fetch the file content
+	body, err := sa.getObject(fileKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// Decode the content into your struct
+	data, err := format.Decode(fileKey, body)
+	if err != nil {
+		return time.Time{}, err
+	}
+	slog.Info("Successfuly parsed endtime","bucket", sa.bucketName, "parentDir", sa.keyParentDir, "file", fileKey, "end", data.End)
+	return data.End, nil
+}
+
+func (sa *S3Adapter) ListRuns() ([]string, error) {
+	prefix := sa.keyParentDir
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	seen := map[string]bool{}
+	var dirs []string
+	err := sa.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(sa.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			key := *object.Key
+			if !format.IsResult(key) {
+				continue
+			}
+			dir := path.Dir(key)
+			if !seen[dir] {
+				seen[dir] = true
+				dirs = append(dirs, dir)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %w", sa.bucketName, err)
+	}
+	slices.Sort(dirs)
+	return dirs, nil
+}
+
+func (sa *S3Adapter) ListResults() ([]string, error) {
+	manifestKey := getS3Key(sa.keyParentDir, domain.ManifestName)
+	var keys []string
+	err := sa.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(sa.bucketName),
+		Prefix:    aws.String(sa.keyParentDir + "/"),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			key := *object.Key
+			if key != manifestKey && format.IsResult(key) && path.Dir(key) == sa.keyParentDir {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list items in bucket %q, %w", sa.bucketName, err)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (sa *S3Adapter) ReadResult(key string) ([]byte, error) {
+	return sa.getObject(key)
+}
+
+func (sa *S3Adapter) WriteResult(key string, data []byte) error {
+	encoder := format.EncoderFor(key)
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(sa.bucketName),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String(encoder.ContentType()),
+	}
+	if encoding := encoder.ContentEncoding(); encoding != "" {
+		input.ContentEncoding = aws.String(encoding)
+	}
+	if _, err := sa.client.PutObject(input); err != nil {
+		return fmt.Errorf("Unable to upload %q to %q, %w", key, sa.bucketName, err)
+	}
+	return nil
+}
+
+func (sa *S3Adapter) putObject(key string, data []byte) error {
+	_, err := sa.client.PutObject(&s3.PutObjectInput{
+		Bucket:        aws.String(sa.bucketName),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String("application/json"),
+	})
+	return err
+}
+
+func (sa *S3Adapter) getObject(key string) ([]byte, error) {
 	getResp, err := sa.client.GetObject(&s3.GetObjectInput{
 		Bucket: aws.String(sa.bucketName),
-		Key:    aws.String(fileKey),
+		Key:    aws.String(key),
 	})
 	if err != nil {
-		return 0, fmt.Errorf("Unable to download item %q, %w", fileKey, err)
+		return nil, fmt.Errorf("Unable to download item %q, %w", key, err)
 	}
 	defer getResp.Body.Close()
 
-	// Read the file content
 	body, err := io.ReadAll(getResp.Body)
 	if err != nil {
-		return 0, fmt.Errorf("Failed to read file content, %w", err)
-	}
-
-	// Unmarshal the content into your struct
-	var data domain.MetricsMatrix
-	if err := json.Unmarshal(body, &data); err != nil {
-		return 0, fmt.Errorf("Failed to unmarshal JSON, %w", err)
+		return nil, fmt.Errorf("Failed to read file content, %w", err)
 	}
-	slog.Info("Successfuly parsed endtime","bucket", sa.bucketName, "parentDir", sa.keyParentDir, "file", fileKey, "end", data.End)
-	return data.End, nil
+	return body, nil
 }
 
 func getS3Key(prefix, name string) string {
-	return fmt.Sprintf("%s/%s.json", prefix, name)
+	return getResultKey(prefix, name, ".json")
+}
+
+// getResultKey returns the key of a result stored with the extension of its format
+func getResultKey(prefix, name, extension string) string {
+	return fmt.Sprintf("%s/%s%s", prefix, name, extension)
+}
+
+// sessionOptions builds the session for AWS S3 or any S3-compatible store such as MinIO
+func sessionOptions(config *domain.Config) session.Options {
+	awsConfig := aws.NewConfig().
+		WithRegion(config.AWSRegion).
+		WithS3ForcePathStyle(config.S3ForcePathStyle)
+
+	if config.S3Endpoint != "" {
+		awsConfig = awsConfig.WithEndpoint(config.S3Endpoint)
+	}
+	if config.S3InsecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		awsConfig = awsConfig.WithHTTPClient(&http.Client{Transport: transport})
+	}
+	// explicit credentials take precedence over the default credential chain and profiles
+	if config.S3AccessKeyID != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(
+			config.S3AccessKeyID,
+			config.S3SecretAccessKey,
+			config.S3SessionToken,
+		))
+	}
+
+	options := session.Options{Config: *awsConfig}
+	if config.S3Profile != "" {
+		options.Profile = config.S3Profile
+		options.SharedConfigState = session.SharedConfigEnable
+	}
+	return options
 }
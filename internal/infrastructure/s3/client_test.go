@@ -0,0 +1,268 @@
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hanapedia/metrics-processor/internal/domain"
+	"github.com/hanapedia/metrics-processor/internal/infrastructure/format"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdapter(t *testing.T, endpoint string) *S3Adapter {
+	adapter, err := NewS3Adapter(&domain.Config{
+		AWSRegion:         "us-east-1",
+		S3Bucket:          "metrics",
+		S3BucketDir:       "experiment/run-1",
+		S3Endpoint:        endpoint,
+		S3ForcePathStyle:  true,
+		S3AccessKeyID:     "minioadmin",
+		S3SecretAccessKey: "minioadmin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adapter
+}
+
+func TestS3AdapterCompatibleEndpoint(t *testing.T) {
+	fake, server := newFakeS3(t)
+	adapter := newTestAdapter(t, server.URL)
+
+	metricsChan := make(chan *domain.MetricsMatrix, 2)
+	metricsChan <- &domain.MetricsMatrix{
+		Name:   "b_query",
+		Series: []domain.Series{{Labels: model.Metric{}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}}},
+		End:    time.Unix(1700000000, 0),
+	}
+	metricsChan <- &domain.MetricsMatrix{
+		Name:   "a_query",
+		Series: []domain.Series{{Labels: model.Metric{}, Values: []model.SamplePair{{Timestamp: 1000, Value: 2}}}},
+		End:    time.Unix(1700000000, 0),
+	}
+	close(metricsChan)
+
+	report := domain.NewRunReport()
+	adapter.Save(metricsChan, report)
+	assert.Equal(t, 0, report.Failed())
+
+	body, ok := fake.object("metrics", "experiment/run-1/a_query.json")
+	assert.True(t, ok)
+	saved, err := format.Decode("a_query.json", body)
+	assert.NoError(t, err)
+	assert.Equal(t, "a_query", saved.Name)
+
+	end, err := adapter.ParseEndTime()
+	assert.NoError(t, err)
+	assert.True(t, time.Unix(1700000000, 0).Equal(end))
+}
+
+func TestS3AdapterManifest(t *testing.T) {
+	fake, server := newFakeS3(t)
+	adapter := newTestAdapter(t, server.URL)
+
+	metricsChan := make(chan *domain.MetricsMatrix, 1)
+	metricsChan <- &domain.MetricsMatrix{Name: "z_query", End: time.Unix(1609459200, 0)}
+	close(metricsChan)
+	report := domain.NewRunReport()
+	adapter.Save(metricsChan, report)
+
+	config := &domain.Config{EndTime: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), Duration: time.Hour}
+	run := domain.RunInfo{Command: "hexagon", QuerySet: "hexagon", Version: "test", Config: config}
+	assert.NoError(t, adapter.WriteManifest(domain.NewManifest(run, report)))
+	_, ok := fake.object("metrics", "experiment/run-1/manifest.json")
+	assert.True(t, ok)
+
+	manifest, err := adapter.ReadManifest()
+	assert.NoError(t, err)
+	assert.Equal(t, "hexagon", manifest.Command)
+	assert.True(t, config.EndTime.Equal(manifest.End))
+	assert.Equal(t, "experiment/run-1/z_query.json", manifest.Queries[0].Key)
+
+	// the manifest is listed first but must not be used as a result
+	end, err := adapter.ParseEndTime()
+	assert.NoError(t, err)
+	assert.True(t, time.Unix(1609459200, 0).Equal(end))
+}
+
+func TestS3AdapterParseEndTimeEmpty(t *testing.T) {
+	_, server := newFakeS3(t)
+	adapter := newTestAdapter(t, server.URL)
+
+	_, err := adapter.ParseEndTime()
+	assert.Error(t, err)
+}
+
+func TestSessionOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      domain.Config
+		wantProfile string
+		wantCreds   bool
+		wantClient  bool
+	}{
+		{
+			name:   "aws defaults",
+			config: domain.Config{AWSRegion: "ap-northeast-1"},
+		},
+		{
+			name:        "profile",
+			config:      domain.Config{AWSRegion: "ap-northeast-1", S3Profile: "minio"},
+			wantProfile: "minio",
+		},
+		{
+			name: "static credentials and insecure endpoint",
+			config: domain.Config{
+				AWSRegion:            "us-east-1",
+				S3Endpoint:           "https://minio.local:9000",
+				S3InsecureSkipVerify: true,
+				S3AccessKeyID:        "key",
+				S3SecretAccessKey:    "secret",
+			},
+			wantCreds:  true,
+			wantClient: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := sessionOptions(&tt.config)
+			assert.Equal(t, tt.config.AWSRegion, *options.Config.Region)
+			assert.Equal(t, tt.wantProfile, options.Profile)
+			assert.Equal(t, tt.wantCreds, options.Config.Credentials != nil)
+			assert.Equal(t, tt.wantClient, options.Config.HTTPClient != nil)
+			if tt.config.S3Endpoint != "" {
+				assert.Equal(t, tt.config.S3Endpoint, *options.Config.Endpoint)
+			}
+		})
+	}
+}
+
+func TestS3AdapterListRuns(t *testing.T) {
+	fake, server := newFakeS3(t)
+	for _, key := range []string{
+		"experiment/run-1/a_query.json",
+		"experiment/run-1/manifest.json",
+		"experiment/run-2/warmup/a_query.json",
+		"experiment/run-2/steady/a_query.json",
+		"experiment/run-3/notes.txt",
+		"experiment-requery/run-1/a_query.json",
+	} {
+		fake.objects["metrics/"+key] = []byte("{}")
+	}
+	adapter := newTestAdapter(t, server.URL)
+	adapter.keyParentDir = "experiment"
+
+	runs, err := adapter.ListRuns()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"experiment/run-1", "experiment/run-2/steady", "experiment/run-2/warmup"}, runs)
+}
+
+func TestS3AdapterReadStored(t *testing.T) {
+	_, server := newFakeS3(t)
+	adapter := newTestAdapter(t, server.URL)
+
+	metricsChan := make(chan *domain.MetricsMatrix, 1)
+	metricsChan <- &domain.MetricsMatrix{Name: "a_query", Fingerprint: "sha256:a"}
+	close(metricsChan)
+	adapter.Save(metricsChan, domain.NewRunReport())
+
+	stored, err := adapter.ReadStored([]string{"a_query", "b_query"})
+	assert.NoError(t, err)
+	assert.Len(t, stored, 1)
+	assert.Equal(t, "experiment/run-1/a_query.json", stored[0].Key)
+	assert.Equal(t, "sha256:a", stored[0].Fingerprint)
+}
+
+func TestS3AdapterMultipartUpload(t *testing.T) {
+	fake, server := newFakeS3(t)
+	adapter := newTestAdapter(t, server.URL)
+
+	// enough series to exceed a single upload part
+	var series []domain.Series
+	samples := make([]model.SamplePair, 1000)
+	for i := range samples {
+		samples[i] = model.SamplePair{Timestamp: model.Time(i * 1000), Value: model.SampleValue(i)}
+	}
+	for i := 0; i < 600; i++ {
+		series = append(series, domain.Series{Labels: model.Metric{"le": model.LabelValue(fmt.Sprint(i))}, Values: samples})
+	}
+	metricsMatrix := &domain.MetricsMatrix{Name: "histogram", Series: series, End: time.Unix(1700000000, 0)}
+	var buf bytes.Buffer
+	assert.NoError(t, adapter.encoder.Encode(&buf, metricsMatrix))
+	expected := buf.Bytes()
+	assert.Greater(t, len(expected), int(s3manager.MinUploadPartSize))
+
+	metricsChan := make(chan *domain.MetricsMatrix, 1)
+	metricsChan <- metricsMatrix
+	close(metricsChan)
+	report := domain.NewRunReport()
+	adapter.Save(metricsChan, report)
+	assert.Equal(t, 0, report.Failed())
+
+	body, ok := fake.object("metrics", "experiment/run-1/histogram.json")
+	assert.True(t, ok)
+	assert.Equal(t, expected, body)
+	assert.Equal(t, 1, fake.multiparts)
+	assert.Equal(t, domain.Checksum(expected), report.Results()[0].Checksum)
+	assert.Equal(t, int64(len(expected)), report.Results()[0].Bytes)
+}
+
+func TestS3AdapterCompression(t *testing.T) {
+	fake, server := newFakeS3(t)
+	adapter := newTestAdapter(t, server.URL)
+	adapter.encoder = format.NewEncoder(&domain.Config{StorageCompression: domain.CompressionGzip})
+
+	metricsChan := make(chan *domain.MetricsMatrix, 1)
+	metricsChan <- &domain.MetricsMatrix{Name: "a_query", End: time.Unix(1700000000, 0), Fingerprint: "sha256:a"}
+	close(metricsChan)
+	report := domain.NewRunReport()
+	adapter.Save(metricsChan, report)
+	assert.Equal(t, 0, report.Failed())
+
+	body, ok := fake.object("metrics", "experiment/run-1/a_query.json.gz")
+	assert.True(t, ok)
+	assert.Equal(t, []byte{0x1f, 0x8b}, body[:2])
+	assert.Equal(t, "gzip", fake.header("metrics", "experiment/run-1/a_query.json.gz").Get("Content-Encoding"))
+
+	end, err := adapter.ParseEndTime()
+	assert.NoError(t, err)
+	assert.True(t, time.Unix(1700000000, 0).Equal(end))
+
+	adapter.encoder = format.NewEncoder(&domain.Config{StorageCompression: domain.CompressionZstd})
+	stored, err := adapter.ReadStored([]string{"a_query"})
+	assert.NoError(t, err)
+	assert.Len(t, stored, 1)
+	assert.Equal(t, "experiment/run-1/a_query.json.gz", stored[0].Key)
+	assert.Equal(t, "sha256:a", stored[0].Fingerprint)
+}
+
+func TestS3AdapterMigrationResults(t *testing.T) {
+	fake, server := newFakeS3(t)
+	for _, key := range []string{
+		"experiment/run-1/b_query.json.gz",
+		"experiment/run-1/a_query.json",
+		"experiment/run-1/manifest.json",
+		"experiment/run-1/notes.txt",
+		"experiment/run-1/warmup/a_query.json",
+		"experiment/run-10/a_query.json",
+	} {
+		fake.objects["metrics/"+key] = []byte("{}")
+	}
+	adapter := newTestAdapter(t, server.URL)
+
+	keys, err := adapter.ListResults()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"experiment/run-1/a_query.json", "experiment/run-1/b_query.json.gz"}, keys)
+
+	assert.NoError(t, adapter.WriteResult(keys[1], []byte{0x1f, 0x8b}))
+	data, err := adapter.ReadResult(keys[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x1f, 0x8b}, data)
+	assert.Equal(t, "gzip", fake.header("metrics", keys[1]).Get("Content-Encoding"))
+	assert.Equal(t, "application/json", fake.header("metrics", keys[1]).Get("Content-Type"))
+}
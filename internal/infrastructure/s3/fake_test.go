@@ -0,0 +1,165 @@
+package s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal path-style S3 server supporting PutObject, GetObject, ListObjectsV2 and multipart uploads
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+	// uploads holds the parts of multipart uploads in progress by upload id
+	uploads    map[string]map[int][]byte
+	multiparts int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{objects: map[string][]byte{}, headers: map[string]http.Header{}, uploads: map[string]map[int][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+type listBucketResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string   `xml:"Name"`
+	Prefix      string   `xml:"Prefix"`
+	KeyCount    int      `xml:"KeyCount"`
+	MaxKeys     int      `xml:"MaxKeys"`
+	IsTruncated bool     `xml:"IsTruncated"`
+	Contents    []struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	} `xml:"Contents"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	switch {
+	case query.Has("uploads") || query.Has("uploadId"):
+		f.multipart(w, bucket, key, r)
+	case r.Method == http.MethodPut && key != "":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		f.objects[bucket+"/"+key] = body
+		f.headers[bucket+"/"+key] = r.Header.Clone()
+	case r.Method == http.MethodGet && key != "":
+		body, ok := f.objects[bucket+"/"+key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		w.Write(body)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		f.list(w, bucket, r)
+	default:
+		http.Error(w, "unsupported request", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) multipart(w http.ResponseWriter, bucket, key string, r *http.Request) {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.multiparts++
+		uploadID = strconv.Itoa(f.multiparts)
+		f.uploads[uploadID] = map[int][]byte{}
+		f.headers[bucket+"/"+key] = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, bucket, key, uploadID)
+	case r.Method == http.MethodPut:
+		part, err := strconv.Atoi(query.Get("partNumber"))
+		body, readErr := io.ReadAll(r.Body)
+		if err != nil || readErr != nil || f.uploads[uploadID] == nil {
+			http.Error(w, "invalid part", http.StatusBadRequest)
+			return
+		}
+		f.uploads[uploadID][part] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, part))
+	case r.Method == http.MethodPost:
+		parts := f.uploads[uploadID]
+		numbers := make([]int, 0, len(parts))
+		for number := range parts {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		var body []byte
+		for _, number := range numbers {
+			body = append(body, parts[number]...)
+		}
+		f.objects[bucket+"/"+key] = body
+		delete(f.uploads, uploadID)
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"done"</ETag></CompleteMultipartUploadResult>`, bucket, key)
+	case r.Method == http.MethodDelete:
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported request", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket string, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	maxKeys := 1000
+	if v, err := strconv.Atoi(r.URL.Query().Get("max-keys")); err == nil {
+		maxKeys = v
+	}
+
+	var keys []string
+	for name := range f.objects {
+		objectBucket, key, _ := strings.Cut(name, "/")
+		if objectBucket == bucket && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{Name: bucket, Prefix: prefix, MaxKeys: maxKeys}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key  string `xml:"Key"`
+			Size int    `xml:"Size"`
+		}{Key: key, Size: len(f.objects[bucket+"/"+key])})
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) object(bucket, key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, ok := f.objects[bucket+"/"+key]
+	return body, ok
+}
+
+func (f *fakeS3) header(bucket, key string) http.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.headers[bucket+"/"+key]
+}
@@ -0,0 +1,36 @@
+package promql
+
+import "strings"
+
+// Cost estimates the relative cost of evaluating the query.
+// A selector costs 1, or 10 for histogram buckets. Range selectors double the cost of their selector.
+func (q *Query) Cost() int {
+	return exprCost(q.expr)
+}
+
+func exprCost(expr Expr) int {
+	switch e := expr.(type) {
+	case *VectorSelector:
+		if strings.HasSuffix(e.Metric, "_bucket") {
+			return 10
+		}
+		return 1
+	case *MatrixSelector:
+		return 2 * exprCost(e.Vector)
+	case *Call:
+		cost := 0
+		for _, arg := range e.Args {
+			cost += exprCost(arg)
+		}
+		return cost
+	case *AggregateExpr:
+		return exprCost(e.Expr)
+	case *BinaryExpr:
+		return exprCost(e.LHS) + exprCost(e.RHS)
+	case *ParenExpr:
+		return exprCost(e.Expr)
+	case *RawExpr:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,213 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a node in a PromQL expression tree
+type Expr interface {
+	// String renders the expression as PromQL
+	String() string
+	// precedence is the binding strength of the node. Higher binds tighter.
+	precedence() int
+}
+
+// operator precedences, from lowest to highest.
+// Nodes that are never split by a binary operator use precAtom.
+const (
+	precOr = iota + 1
+	precAndUnless
+	precComparison
+	precAdditive
+	precMultiplicative
+	precPower
+	precAtom
+)
+
+var binaryPrecedence = map[string]int{
+	"or":     precOr,
+	"and":    precAndUnless,
+	"unless": precAndUnless,
+	"==":     precComparison,
+	"!=":     precComparison,
+	"<=":     precComparison,
+	"<":      precComparison,
+	">=":     precComparison,
+	">":      precComparison,
+	"+":      precAdditive,
+	"-":      precAdditive,
+	"*":      precMultiplicative,
+	"/":      precMultiplicative,
+	"%":      precMultiplicative,
+	"atan2":  precMultiplicative,
+	"^":      precPower,
+}
+
+// VectorSelector selects series by metric name and label matchers
+type VectorSelector struct {
+	Metric   string
+	Matchers []Filter
+	Offset   time.Duration
+}
+
+func (vs *VectorSelector) String() string {
+	return vs.selector() + renderOffset(vs.Offset)
+}
+
+// selector renders the selector without offset
+func (vs *VectorSelector) selector() string {
+	if len(vs.Matchers) == 0 {
+		return vs.Metric
+	}
+	return fmt.Sprintf("%s{%s}", vs.Metric, flattenFilters(vs.Matchers))
+}
+
+func (vs *VectorSelector) precedence() int { return precAtom }
+
+// MatrixSelector selects a range of samples for a vector selector.
+// Offset of the underlying selector is rendered after the range as required by PromQL.
+type MatrixSelector struct {
+	Vector Expr
+	Range  time.Duration
+}
+
+func (ms *MatrixSelector) String() string {
+	if vs, ok := ms.Vector.(*VectorSelector); ok {
+		return fmt.Sprintf("%s[%s]%s", vs.selector(), ms.Range, renderOffset(vs.Offset))
+	}
+	return fmt.Sprintf("%s[%s]", ms.Vector, ms.Range)
+}
+
+func (ms *MatrixSelector) precedence() int { return precAtom }
+
+// Call is a function call
+type Call struct {
+	Func string
+	Args []Expr
+}
+
+func (c *Call) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, arg := range c.Args {
+		args = append(args, arg.String())
+	}
+	return fmt.Sprintf("%s(%s)", c.Func, strings.Join(args, ","))
+}
+
+func (c *Call) precedence() int { return precAtom }
+
+// AggregateExpr is an aggregation such as sum or min with a by clause
+type AggregateExpr struct {
+	Op       string
+	Grouping []string
+	Expr     Expr
+}
+
+func (ae *AggregateExpr) String() string {
+	return fmt.Sprintf("%s by (%s)(%s)", ae.Op, strings.Join(ae.Grouping, ","), ae.Expr)
+}
+
+func (ae *AggregateExpr) precedence() int { return precAtom }
+
+// BinaryExpr is a binary operation. Operands are parenthesized as required by operator precedence.
+type BinaryExpr struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+func (be *BinaryExpr) String() string {
+	prec := be.precedence()
+	lhs, rhs := be.LHS.String(), be.RHS.String()
+	// "^" is right associative, every other operator is left associative
+	if be.Op == "^" {
+		if be.LHS.precedence() <= prec {
+			lhs = parenthesize(lhs)
+		}
+		if be.RHS.precedence() < prec {
+			rhs = parenthesize(rhs)
+		}
+	} else {
+		if be.LHS.precedence() < prec {
+			lhs = parenthesize(lhs)
+		}
+		if be.RHS.precedence() <= prec {
+			rhs = parenthesize(rhs)
+		}
+	}
+	return fmt.Sprintf("%s %s %s", lhs, be.Op, rhs)
+}
+
+func (be *BinaryExpr) precedence() int {
+	if prec, ok := binaryPrecedence[be.Op]; ok {
+		return prec
+	}
+	return precOr
+}
+
+// ParenExpr is an explicitly parenthesized expression
+type ParenExpr struct {
+	Expr Expr
+}
+
+func (pe *ParenExpr) String() string {
+	return parenthesize(pe.Expr.String())
+}
+
+func (pe *ParenExpr) precedence() int { return precAtom }
+
+// NumberLiteral is a scalar literal. Text is rendered verbatim.
+type NumberLiteral struct {
+	Text string
+}
+
+func (nl *NumberLiteral) String() string { return nl.Text }
+
+func (nl *NumberLiteral) precedence() int { return precAtom }
+
+// StringLiteral is a double quoted string argument
+type StringLiteral struct {
+	Value string
+}
+
+func (sl *StringLiteral) String() string { return fmt.Sprintf(`"%s"`, sl.Value) }
+
+func (sl *StringLiteral) precedence() int { return precAtom }
+
+// RawExpr is an expression given as text that could not be classified.
+// It is parenthesized whenever it is used as an operand since its precedence is unknown.
+type RawExpr struct {
+	Text string
+}
+
+func (re *RawExpr) String() string { return re.Text }
+
+func (re *RawExpr) precedence() int { return precOr - 1 }
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// parseExpr classifies text given to NewQuery
+func parseExpr(text string) Expr {
+	if _, err := strconv.ParseFloat(text, 64); err == nil {
+		return &NumberLiteral{Text: text}
+	}
+	if metricNamePattern.MatchString(text) {
+		return &VectorSelector{Metric: text}
+	}
+	return &RawExpr{Text: text}
+}
+
+func parenthesize(s string) string {
+	return fmt.Sprintf("(%s)", s)
+}
+
+func renderOffset(offset time.Duration) string {
+	if offset == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" offset %s", offset)
+}
@@ -7,9 +7,42 @@ import (
 	"time"
 )
 
+// Query is a named PromQL expression built with the fluent builder methods.
+// Builder methods modify the query in place and return it for chaining.
 type Query struct {
 	Name string
-	q    string
+	expr Expr
+	// timeout and retries override the settings of the query adapter when set
+	timeout time.Duration
+	retries *int
+	// priority orders queries before they are dispatched. Higher runs first.
+	priority int
+	// unit of the result values, stored with the result
+	unit string
+	// mode selects whether the query is evaluated over the query range or at its end
+	mode Mode
+}
+
+// Mode selects how a query is evaluated
+type Mode string
+
+const (
+	// ModeRange evaluates the query at every step of the query range
+	ModeRange Mode = "range"
+	// ModeInstant evaluates the query once, at the end of the query range
+	ModeInstant Mode = "instant"
+)
+
+// ParseMode parses the mode of a query. An empty string is ModeRange.
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "", ModeRange:
+		return ModeRange, nil
+	case ModeInstant:
+		return ModeInstant, nil
+	default:
+		return "", fmt.Errorf("invalid query mode %q, must be %s or %s", mode, ModeRange, ModeInstant)
+	}
 }
 
 type Filter struct {
@@ -18,9 +51,17 @@ type Filter struct {
 	value    string
 }
 
+// NewQuery creates a query from a metric name, a number literal or an arbitrary PromQL expression
 func NewQuery(q string) *Query {
 	return &Query{
-		q: q,
+		expr: parseExpr(q),
+	}
+}
+
+// NewQueryFromExpr creates a query from an expression tree
+func NewQueryFromExpr(expr Expr) *Query {
+	return &Query{
+		expr: expr,
 	}
 }
 
@@ -29,6 +70,73 @@ func (q *Query) SetName(name string) *Query {
 	return q
 }
 
+// SetTimeout overrides the timeout of the query adapter for this query
+func (q *Query) SetTimeout(timeout time.Duration) *Query {
+	q.timeout = timeout
+	return q
+}
+
+// Timeout returns the timeout override, or 0 when not set
+func (q *Query) Timeout() time.Duration {
+	return q.timeout
+}
+
+// SetRetries overrides the retry count of the query adapter for this query
+func (q *Query) SetRetries(retries int) *Query {
+	q.retries = &retries
+	return q
+}
+
+// Retries returns the retry count override and whether it is set
+func (q *Query) Retries() (int, bool) {
+	if q.retries == nil {
+		return 0, false
+	}
+	return *q.retries, true
+}
+
+// SetPriority sets the dispatch priority of the query. Higher runs first, default is 0.
+// Queries with the same priority are dispatched in order of Cost.
+func (q *Query) SetPriority(priority int) *Query {
+	q.priority = priority
+	return q
+}
+
+// Priority returns the dispatch priority of the query
+func (q *Query) Priority() int {
+	return q.priority
+}
+
+// SetUnit sets the unit of the result values such as seconds or bytes
+func (q *Query) SetUnit(unit string) *Query {
+	q.unit = unit
+	return q
+}
+
+// Unit returns the unit of the result values, or an empty string when unknown
+func (q *Query) Unit() string {
+	return q.unit
+}
+
+// SetMode sets whether the query is evaluated over the query range or at its end
+func (q *Query) SetMode(mode Mode) *Query {
+	q.mode = mode
+	return q
+}
+
+// Mode returns how the query is evaluated, ModeRange unless set
+func (q *Query) Mode() Mode {
+	if q.mode == "" {
+		return ModeRange
+	}
+	return q.mode
+}
+
+// Expr returns the expression tree of the query
+func (q *Query) Expr() Expr {
+	return q.expr
+}
+
 func NewFilter(label, operator, value string) Filter {
 	return Filter{
 		label:    label,
@@ -37,8 +145,30 @@ func NewFilter(label, operator, value string) Filter {
 	}
 }
 
+func (f *Filter) Label() string {
+	return f.label
+}
+
+func (f *Filter) Operator() string {
+	return f.operator
+}
+
+func (f *Filter) Value() string {
+	return f.value
+}
+
+// Filter adds label matchers to the vector selector
 func (q *Query) Filter(filters []Filter) *Query {
-	q.q = fmt.Sprintf("%s{%s}", q.q, flattenFilters(filters))
+	vs, ok := q.expr.(*VectorSelector)
+	if !ok {
+		slog.Warn("Filter can only be applied to a vector selector. Ignoring.", "query", q.AsString())
+		return q
+	}
+	q.expr = &VectorSelector{
+		Metric:   vs.Metric,
+		Matchers: append(append([]Filter{}, vs.Matchers...), filters...),
+		Offset:   vs.Offset,
+	}
 	return q
 }
 
@@ -60,31 +190,36 @@ func flattenFilters(filters []Filter) string {
 }
 
 func (q *Query) AsString() string {
-	return q.q
+	return q.expr.String()
 }
 
+// Group explicitly parenthesizes the query.
+// Binary operations parenthesize their operands when needed, so this is only required for readability.
 func (q *Query) Group() *Query {
-	q.q = fmt.Sprintf("(%s)", q.q)
+	if _, ok := q.expr.(*ParenExpr); ok {
+		return q
+	}
+	q.expr = &ParenExpr{Expr: q.expr}
 	return q
 }
 
 func (q *Query) Rate(duration time.Duration) *Query {
-	q.q = fmt.Sprintf("rate(%s[%s])", q.q, duration)
+	q.expr = &Call{Func: "rate", Args: []Expr{&MatrixSelector{Vector: q.expr, Range: duration}}}
 	return q
 }
 
 func (q *Query) IRate(duration time.Duration) *Query {
-	q.q = fmt.Sprintf("irate(%s[%s])", q.q, duration)
+	q.expr = &Call{Func: "irate", Args: []Expr{&MatrixSelector{Vector: q.expr, Range: duration}}}
 	return q
 }
 
 func (q *Query) SumBy(byStrs []string) *Query {
-	q.q = fmt.Sprintf("sum by (%s)(%s)", strings.Join(byStrs, ","), q.q)
+	q.expr = &AggregateExpr{Op: "sum", Grouping: byStrs, Expr: q.expr}
 	return q
 }
 
 func (q *Query) MinBy(byStrs []string) *Query {
-	q.q = fmt.Sprintf("min by (%s)(%s)", strings.Join(byStrs, ","), q.q)
+	q.expr = &AggregateExpr{Op: "min", Grouping: byStrs, Expr: q.expr}
 	return q
 }
 
@@ -93,31 +228,47 @@ func (q *Query) HistogramQuantile(quantile float32) *Query {
 		slog.Warn("Invalid quantile, defaulting to 0.5")
 		quantile = 0.5
 	}
-	q.q = fmt.Sprintf("histogram_quantile(%v,%s)", quantile, q.q)
+	q.expr = &Call{Func: "histogram_quantile", Args: []Expr{&NumberLiteral{Text: fmt.Sprintf("%v", quantile)}, q.expr}}
 	return q
 }
 
 func (q *Query) Subtract(aq *Query) *Query {
-	q.q = fmt.Sprintf("%s - %s", q.q, aq.q)
+	q.expr = &BinaryExpr{Op: "-", LHS: q.expr, RHS: aq.expr}
 	return q
 }
 
 func (q *Query) Divide(aq *Query) *Query {
-	q.q = fmt.Sprintf("%s / %s", q.q, aq.q)
+	q.expr = &BinaryExpr{Op: "/", LHS: q.expr, RHS: aq.expr}
 	return q
 }
 
 func (q *Query) MultiplyByConstant(c int) *Query {
-	q.q = fmt.Sprintf("%s * %v", q.q, c)
+	q.expr = &BinaryExpr{Op: "*", LHS: q.expr, RHS: &NumberLiteral{Text: fmt.Sprintf("%v", c)}}
 	return q
 }
 
 func (q *Query) LabelReplace(target, source, pattern string) *Query {
-	q.q = fmt.Sprintf(`label_replace(%s,"%s","$1","%s","%s")`, q.q, target, source, pattern)
+	q.expr = &Call{Func: "label_replace", Args: []Expr{
+		q.expr,
+		&StringLiteral{Value: target},
+		&StringLiteral{Value: "$1"},
+		&StringLiteral{Value: source},
+		&StringLiteral{Value: pattern},
+	}}
 	return q
 }
 
+// Offset sets the offset of the vector selector
 func (q *Query) Offset(duration time.Duration) *Query {
-	q.q = fmt.Sprintf("%s offset %s", q.q, duration)
+	vs, ok := q.expr.(*VectorSelector)
+	if !ok {
+		slog.Warn("Offset can only be applied to a vector selector. Ignoring.", "query", q.AsString())
+		return q
+	}
+	q.expr = &VectorSelector{
+		Metric:   vs.Metric,
+		Matchers: vs.Matchers,
+		Offset:   duration,
+	}
 	return q
 }
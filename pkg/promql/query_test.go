@@ -0,0 +1,132 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryAsString(t *testing.T) {
+	filters := []Filter{NewFilter("namespace", "=", "emulation")}
+	ratio := func() *Query {
+		return NewQuery("a").Filter(filters).Rate(time.Minute).SumBy([]string{"pod"}).
+			Divide(NewQuery("b").Filter(filters).Rate(time.Minute).SumBy([]string{"pod"}))
+	}
+
+	tests := []struct {
+		name     string
+		query    *Query
+		expected string
+	}{
+		{
+			name:     "selector with filters",
+			query:    NewQuery("a").Filter(filters),
+			expected: `a{namespace="emulation"}`,
+		},
+		{
+			name:     "rate with offset renders offset after range",
+			query:    NewQuery("a").Filter(filters).Offset(15 * time.Second).Rate(5 * time.Minute),
+			expected: `rate(a{namespace="emulation"}[5m0s] offset 15s)`,
+		},
+		{
+			name:     "aggregation and histogram quantile",
+			query:    NewQuery("a_bucket").IRate(time.Minute).SumBy([]string{"pod", "le"}).HistogramQuantile(0.99),
+			expected: `histogram_quantile(0.99,sum by (pod,le)(irate(a_bucket[1m0s])))`,
+		},
+		{
+			name:     "subtract omits parens for higher-precedence division",
+			query:    NewQuery("1").Subtract(ratio()),
+			expected: `1 - sum by (pod)(rate(a{namespace="emulation"}[1m0s])) / sum by (pod)(rate(b{namespace="emulation"}[1m0s]))`,
+		},
+		{
+			name:     "divide parenthesizes lower-precedence subtraction",
+			query:    NewQuery("1").Divide(NewQuery("a").Subtract(NewQuery("b"))),
+			expected: `1 / (a - b)`,
+		},
+		{
+			name:     "subtract with explicit group is not double parenthesized",
+			query:    NewQuery("1").Subtract(ratio().Group()),
+			expected: `1 - (sum by (pod)(rate(a{namespace="emulation"}[1m0s])) / sum by (pod)(rate(b{namespace="emulation"}[1m0s])))`,
+		},
+		{
+			name:     "division parenthesizes subtraction operands",
+			query:    NewQuery("a").Subtract(NewQuery("b")).Divide(NewQuery("c").Subtract(NewQuery("d"))),
+			expected: `(a - b) / (c - d)`,
+		},
+		{
+			name:     "right operand of same precedence is parenthesized",
+			query:    NewQuery("a").Subtract(NewQuery("b").Subtract(NewQuery("c"))),
+			expected: `a - (b - c)`,
+		},
+		{
+			name:     "left operand of same precedence is not parenthesized",
+			query:    NewQuery("a").Subtract(NewQuery("b")).Subtract(NewQuery("c")),
+			expected: `a - b - c`,
+		},
+		{
+			name:     "multiply by constant",
+			query:    NewQuery("a").SumBy([]string{"name"}).MultiplyByConstant(1000),
+			expected: `sum by (name)(a) * 1000`,
+		},
+		{
+			name:     "raw expression operand is parenthesized",
+			query:    NewQuery("a + b").Divide(NewQuery("c")),
+			expected: `(a + b) / c`,
+		},
+		{
+			name:     "label replace",
+			query:    NewQuery("a").LabelReplace("deployment", "pod", "(.*)-[^-]+"),
+			expected: `label_replace(a,"deployment","$1","pod","(.*)-[^-]+")`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.query.AsString())
+		})
+	}
+}
+
+func TestQueryOperandIsNotAffectedByLaterChanges(t *testing.T) {
+	rhs := NewQuery("b")
+	query := NewQuery("a").Divide(rhs)
+	rhs.Filter([]Filter{NewFilter("pod", "=", "x")}).SumBy([]string{"pod"})
+
+	assert.Equal(t, "a / b", query.AsString())
+}
+
+func TestQueryCost(t *testing.T) {
+	gauge := NewQuery("a").SumBy([]string{"pod"})
+	counter := NewQuery("a_count").Rate(time.Minute)
+	ratio := NewQuery("a_sum").Rate(time.Minute).Divide(NewQuery("a_count").Rate(time.Minute))
+	histogram := NewQuery("a_bucket").Rate(time.Minute).SumBy([]string{"le"}).HistogramQuantile(0.99)
+
+	assert.Equal(t, 1, gauge.Cost())
+	assert.Equal(t, 2, counter.Cost())
+	assert.Equal(t, 4, ratio.Cost())
+	assert.Equal(t, 20, histogram.Cost())
+}
+
+func TestQueryMode(t *testing.T) {
+	assert.Equal(t, ModeRange, NewQuery("a").Mode())
+	assert.Equal(t, ModeInstant, NewQuery("a").SetMode(ModeInstant).Mode())
+
+	tests := []struct {
+		mode     string
+		expected Mode
+		err      bool
+	}{
+		{mode: "", expected: ModeRange},
+		{mode: "range", expected: ModeRange},
+		{mode: "instant", expected: ModeInstant},
+		{mode: "vector", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			mode, err := ParseMode(tt.mode)
+			assert.Equal(t, tt.err, err != nil)
+			assert.Equal(t, tt.expected, mode)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package promql
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+var matcherOperators = map[string]bool{
+	"=":  true,
+	"!=": true,
+	"=~": true,
+	"!~": true,
+}
+
+// Validate checks the query without sending it to Prometheus.
+// It checks every label matcher and parses the rendered expression with the upstream PromQL parser.
+// All problems found are returned joined, each prefixed with the query name.
+func (q *Query) Validate() error {
+	var errs []error
+	for _, filter := range selectorMatchers(q.expr) {
+		if err := filter.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("query %q: %w", q.Name, err))
+		}
+	}
+	if _, err := parser.ParseExpr(q.AsString()); err != nil {
+		errs = append(errs, fmt.Errorf("query %q: invalid PromQL, %w", q.Name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateRanges checks that the range of every range selector, such as the window of rate, spans at least min
+func (q *Query) ValidateRanges(min time.Duration) error {
+	var errs []error
+	for _, r := range selectorRanges(q.expr) {
+		if r < min {
+			errs = append(errs, fmt.Errorf("query %q: range %s is shorter than %s", q.Name, r, min))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *Filter) validate() error {
+	if !model.LabelName(f.label).IsValid() {
+		return fmt.Errorf("invalid label name %q", f.label)
+	}
+	if !matcherOperators[f.operator] {
+		return fmt.Errorf("invalid operator %q for label %q, must be one of =, !=, =~, !~", f.operator, f.label)
+	}
+	if f.operator == "=~" || f.operator == "!~" {
+		// Prometheus anchors regex matchers on both ends
+		if _, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", f.value)); err != nil {
+			return fmt.Errorf("invalid regex %q for label %q, %w", f.value, f.label, err)
+		}
+	}
+	return nil
+}
+
+// selectorMatchers collects the matchers of every vector selector in the expression
+func selectorMatchers(expr Expr) []Filter {
+	var filters []Filter
+	switch e := expr.(type) {
+	case *VectorSelector:
+		filters = append(filters, e.Matchers...)
+	case *MatrixSelector:
+		filters = append(filters, selectorMatchers(e.Vector)...)
+	case *Call:
+		for _, arg := range e.Args {
+			filters = append(filters, selectorMatchers(arg)...)
+		}
+	case *AggregateExpr:
+		filters = append(filters, selectorMatchers(e.Expr)...)
+	case *BinaryExpr:
+		filters = append(filters, selectorMatchers(e.LHS)...)
+		filters = append(filters, selectorMatchers(e.RHS)...)
+	case *ParenExpr:
+		filters = append(filters, selectorMatchers(e.Expr)...)
+	}
+	return filters
+}
+
+// selectorRanges collects the range of every range selector in the expression
+func selectorRanges(expr Expr) []time.Duration {
+	var ranges []time.Duration
+	switch e := expr.(type) {
+	case *MatrixSelector:
+		ranges = append(ranges, e.Range)
+		ranges = append(ranges, selectorRanges(e.Vector)...)
+	case *Call:
+		for _, arg := range e.Args {
+			ranges = append(ranges, selectorRanges(arg)...)
+		}
+	case *AggregateExpr:
+		ranges = append(ranges, selectorRanges(e.Expr)...)
+	case *BinaryExpr:
+		ranges = append(ranges, selectorRanges(e.LHS)...)
+		ranges = append(ranges, selectorRanges(e.RHS)...)
+	case *ParenExpr:
+		ranges = append(ranges, selectorRanges(e.Expr)...)
+	}
+	return ranges
+}
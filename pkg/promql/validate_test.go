@@ -0,0 +1,71 @@
+package promql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   *Query
+		errs    []string
+		isValid bool
+	}{
+		{
+			name: "valid query",
+			query: NewQuery("a").Filter([]Filter{NewFilter("status", "=~", "ok|error")}).
+				Rate(5 * time.Minute).SumBy([]string{"pod"}),
+			isValid: true,
+		},
+		{
+			name:  "invalid operator",
+			query: NewQuery("a").Filter([]Filter{NewFilter("status", "==", "ok")}),
+			errs:  []string{`invalid operator "==" for label "status"`, "invalid PromQL"},
+		},
+		{
+			name:  "invalid regex",
+			query: NewQuery("a").Filter([]Filter{NewFilter("status", "!~", "(ok")}),
+			errs:  []string{`invalid regex "(ok" for label "status"`},
+		},
+		{
+			name:  "invalid label name",
+			query: NewQuery("a").Filter([]Filter{NewFilter("1status", "=", "ok")}),
+			errs:  []string{`invalid label name "1status"`},
+		},
+		{
+			name: "all problems in both operands are reported",
+			query: NewQuery("a").Filter([]Filter{NewFilter("x", "~", "1")}).
+				Divide(NewQuery("b").Filter([]Filter{NewFilter("y", "=~", "[")})),
+			errs: []string{`invalid operator "~" for label "x"`, `invalid regex "[" for label "y"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.query.SetName("test_query").Validate()
+			if tt.isValid {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			for _, msg := range tt.errs {
+				assert.Contains(t, err.Error(), msg)
+			}
+			assert.Contains(t, err.Error(), `query "test_query"`)
+		})
+	}
+}
+
+func TestQueryValidateRanges(t *testing.T) {
+	query := NewQuery("a").Rate(5 * time.Minute).
+		Divide(NewQuery("b").IRate(30 * time.Second)).
+		SetName("test_query")
+
+	assert.NoError(t, query.ValidateRanges(30*time.Second))
+	err := query.ValidateRanges(2 * time.Minute)
+	assert.ErrorContains(t, err, `query "test_query": range 30s is shorter than 2m0s`)
+	assert.NotContains(t, err.Error(), "range 5m0s")
+}